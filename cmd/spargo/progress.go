@@ -0,0 +1,99 @@
+package main
+
+// Progress display for long provenance harvests. Provenance for
+// thousands of entities can take many minutes to retrieve so we let the
+// operator know how far through we are on stderr, as long as stderr is
+// a terminal that can be redrawn.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ross-spencer/wikiprov/pkg/spargo"
+)
+
+// progressInterval is the most often the progress display is redrawn.
+const progressInterval = 200 * time.Millisecond
+
+// progressDisplay writes the progress of a harvest to a terminal.
+type progressDisplay struct {
+	mutex  sync.Mutex
+	out    io.Writer
+	start  time.Time
+	drawn  time.Time
+	latest spargo.Progress
+}
+
+// newProgressDisplay returns a progressDisplay writing to out.
+func newProgressDisplay(out io.Writer) *progressDisplay {
+	return &progressDisplay{out: out}
+}
+
+// isTerminal tells the caller whether the file is a terminal rather
+// than, e.g. a pipe or a file that output has been redirected to.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return (info.Mode() & os.ModeCharDevice) != 0
+}
+
+// update records the latest progress, redrawing the display if it
+// hasn't been drawn recently.
+func (display *progressDisplay) update(progress spargo.Progress) {
+	display.mutex.Lock()
+	defer display.mutex.Unlock()
+	if display.start.IsZero() {
+		// Time the harvest from when provenance is first requested
+		// rather than from the SPARQL query that preceded it.
+		display.start = time.Now()
+	}
+	display.latest = progress
+	if time.Since(display.drawn) < progressInterval && progress.Done < progress.Total {
+		return
+	}
+	display.draw()
+}
+
+// finish draws the final progress and moves to a new line so that
+// further output isn't written over it.
+func (display *progressDisplay) finish() {
+	display.mutex.Lock()
+	defer display.mutex.Unlock()
+	if display.drawn.IsZero() {
+		return
+	}
+	display.draw()
+	fmt.Fprintln(display.out)
+}
+
+// draw writes the latest progress over the previous line. The caller
+// must hold the mutex.
+func (display *progressDisplay) draw() {
+	display.drawn = time.Now()
+	progress := display.latest
+	elapsed := time.Since(display.start)
+	rate := float64(progress.Done) / elapsed.Seconds()
+	eta := "--"
+	if rate > 0 && progress.Done < progress.Total {
+		remaining := float64(progress.Total-progress.Done) / rate
+		eta = time.Duration(remaining * float64(time.Second)).Round(time.Second).String()
+	}
+	// Clear to the end of the line in case the previous line was
+	// longer.
+	const clearLine = "\033[K"
+	fmt.Fprintf(
+		display.out,
+		"\rprovenance: %d/%d fetched, %d failed, %.1f/s, ETA: %s%s",
+		progress.Done-progress.Errors,
+		progress.Total,
+		progress.Errors,
+		rate,
+		eta,
+		clearLine,
+	)
+}
@@ -2,15 +2,21 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ross-spencer/wikiprov/internal/flags"
 	"github.com/ross-spencer/wikiprov/pkg/spargo"
+	"github.com/ross-spencer/wikiprov/pkg/wikiprov"
 )
 
 // SHEBANG provides some way of recognizing a .sparql file compatible
@@ -28,6 +34,11 @@ const WIKIBASEURL string = "WIKIBASEURL"
 // HISTORY describes the length of history to return.
 const HISTORY string = "HISTORY"
 
+// ASOF describes the date or time to return provenance as of.
+// HISTORYAT is an alias of ASOF.
+const ASOF string = "ASOF"
+const HISTORYAT string = "HISTORY_AT"
+
 // SUBJECTPARAM describes the ?param to use as the subject of the query
 // for which we want provenance for.
 const SUBJECTPARAM string = "SUBJECTPARAM"
@@ -37,12 +48,19 @@ const SUBJECTPARAM string = "SUBJECTPARAM"
 const wikiEndpoint string = "https://query.wikidata.org/sparql"
 
 var (
-	vers       bool
-	query      string
-	endpoint   string
-	param      string
-	lenHistory int
-	threads    int
+	vers        bool
+	query       string
+	endpoint    string
+	param       string
+	threads     int
+	snapDir     string
+	rate        float64
+	burst       int
+	adaptive    bool
+	debug       bool
+	quiet       bool
+	optionFlags flags.Options
+	clientFlags flags.Client
 )
 
 type wbQuery struct {
@@ -52,6 +70,7 @@ type wbQuery struct {
 	param    string
 	subject  string
 	history  int
+	asOf     time.Time
 }
 
 func (wb wbQuery) String() string {
@@ -62,8 +81,33 @@ func init() {
 	flag.StringVar(&endpoint, "endpoint", "", "endpoint to query")
 	flag.StringVar(&query, "query", "", "sparql query to run")
 	flag.StringVar(&param, "param", "", "for provenance a SPARQL ?param needs to be specified that contains a Wikidata IRI")
-	flag.IntVar(&lenHistory, "history", 5, "length of history to return to the caller")
+	flag.IntVar(&optionFlags.History, "history", 5, "length of history to return to the caller")
 	flag.IntVar(&threads, "threads", 10, "number of go routines to use to fetch provenance")
+	flag.StringVar(&optionFlags.Properties, "properties", "", "comma separated property IDs to limit provenance history to, e.g. P2748,P4152")
+	flag.IntVar(&clientFlags.MaxLag, "maxlag", 5, "maxlag in seconds to send to Wikibase, 0 to disable")
+	flag.IntVar(&clientFlags.Retries, "retries", wikiprov.DefaultRetryPolicy.MaxAttempts, "maximum attempts for each provenance request")
+	flag.BoolVar(&clientFlags.RenderHistory, "strings", false, "include provenance history as pre-formatted strings")
+	flag.BoolVar(&optionFlags.FollowRedirects, "follow-redirects", false, "return provenance of the items redirected, e.g. merged, entities point to")
+	flag.BoolVar(&optionFlags.Creation, "created", false, "include the date each entity was created and its creator")
+	flag.StringVar(&optionFlags.RevisionProperties, "revprops", "", "comma separated revision properties to request, e.g. ids,user,comment,timestamp,sha1,tags,size")
+	flag.StringVar(&clientFlags.Proxy, "proxy", "", "proxy URL to connect through, read from HTTPS_PROXY etc. if not set")
+	flag.StringVar(&clientFlags.CAFile, "cacert", "", "PEM file of certificate authorities to trust alongside the system's")
+	flag.Float64Var(&rate, "rate", 0, "maximum provenance requests per second across all threads, 0 for no limit")
+	flag.IntVar(&burst, "burst", 1, "number of provenance requests that can be made at once within the rate")
+	flag.BoolVar(&adaptive, "adaptive", false, "adjust the number of threads to how quickly Wikibase responds, up to -threads")
+	flag.BoolVar(&debug, "debug", false, "write debug output, e.g. changes in adaptive concurrency, to stderr")
+	flag.BoolVar(&quiet, "quiet", false, "don't display the progress of provenance retrieval on stderr")
+	flag.StringVar(&clientFlags.CacheDir, "cache", "", "directory to cache provenance in between runs, checked against each entity's latest revision")
+	flag.StringVar(&optionFlags.AsOf, "asof", "", "return provenance as it was at a date or time, e.g. 2023-01-01 or 2023-01-01T12:00:00Z")
+	flag.StringVar(&optionFlags.Since, "since", "", "only return revisions made at or after a date or time, e.g. 2023-01-01")
+	flag.StringVar(&optionFlags.Until, "until", "", "only return revisions made at or before a date or time, e.g. 2023-03-31T23:59:59Z")
+	flag.StringVar(&optionFlags.Users, "users", "", "comma separated users to limit history to")
+	flag.StringVar(&optionFlags.ExcludeUsers, "exclude-users", "", "comma separated users to leave out of history")
+	flag.BoolVar(&optionFlags.ExcludeBots, "exclude-bots", false, "leave revisions made by bots out of history")
+	flag.BoolVar(&optionFlags.ExcludeAnonymous, "exclude-anonymous", false, "leave revisions made by anonymous, i.e. IP address, editors out of history")
+	flag.BoolVar(&optionFlags.Snapshot, "snapshot", false, "include the JSON of each entity at the revision provenance describes")
+	flag.StringVar(&snapDir, "snapshot-dir", "", "directory to write the JSON of each entity at the revision provenance describes to, rather than include it")
+	flag.DurationVar(&clientFlags.Timeout, "timeout", wikiprov.DefaultTransportConfig.ResponseHeaderTimeout, "time to wait for a server to respond to each request")
 	flag.BoolVar(&vers, "version", false, "application version and user-agent")
 }
 
@@ -77,6 +121,14 @@ func extractKey(line string, key string) string {
 	return strings.TrimSpace(str[1])
 }
 
+// isKey tells the caller whether the line sets the given key. Unlike
+// the original keys, these are short enough to appear in a query, e.g.
+// ?hasOfficialWebsite contains ASOF, so we need the line to begin with
+// the key.
+func isKey(line string, key string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), fmt.Sprintf("%s=", key))
+}
+
 // Extract the query from the .sparql input.
 func extractQuery(sparqlFile string) (wbQuery, error) {
 	var err error
@@ -96,6 +148,12 @@ func extractQuery(sparqlFile string) (wbQuery, error) {
 		} else if strings.Contains(strings.ToUpper(line), WIKIBASEURL) {
 			wbURL := extractKey(line, WIKIBASEURL)
 			wb.wikibase = wbURL
+		} else if isKey(line, HISTORYAT) || isKey(line, ASOF) {
+			// HISTORY_AT contains HISTORY so it must be checked first.
+			var asOfErr error
+			if wb.asOf, asOfErr = flags.ParseTime(extractKey(line, ASOF)); asOfErr != nil {
+				err = asOfErr
+			}
 		} else if strings.Contains(strings.ToUpper(line), HISTORY) {
 			wbURL := extractKey(line, HISTORY)
 			wb.history, _ = strconv.Atoi(wbURL)
@@ -116,16 +174,82 @@ func runQuery(sparqlFile string) {
 	}
 	fmt.Fprintf(os.Stderr, "connecting to: %s", wb)
 	fmt.Fprintf(os.Stderr, "threads: %d\n", threads)
+	if rate > 0 {
+		fmt.Fprintf(os.Stderr, "rate: %g requests/second (burst: %d)\n", rate, burst)
+	}
 	if wb.param == "" {
 		fmt.Fprintf(os.Stderr, "?param not set, not returning provenance for query\n")
 	}
-	provResults, err := spargo.SPARQLWithProv(wb.url, wb.query, wb.param, wb.history, threads)
+	if err := clientFlags.Configure(wikiprov.DefaultClient()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	// Interrupting the app returns the results collected so far.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	opts := spargo.Options{Threads: threads}
+	optionFlags.Snapshot = optionFlags.Snapshot || snapDir != ""
+	if opts.Options, err = optionFlags.Parse(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	opts.History = wb.history
+	if opts.AsOf.IsZero() {
+		// The command line takes precedence over the file.
+		opts.AsOf = wb.asOf
+	}
+	opts.Rate = rate
+	opts.Burst = burst
+	opts.Adaptive = adaptive
+	if !opts.AsOf.IsZero() {
+		fmt.Fprintf(os.Stderr, "as of: %s\n", opts.AsOf.UTC().Format(time.RFC3339))
+	}
+	if debug {
+		opts.Debug = os.Stderr
+	}
+	// Progress is only displayed where it can be redrawn and isn't
+	// interleaved with debug output.
+	var display *progressDisplay
+	if !quiet && !debug && isTerminal(os.Stderr) {
+		display = newProgressDisplay(os.Stderr)
+		opts.Progress = display.update
+	}
+	provResults, err := spargo.SPARQLWithProvOptions(ctx, wb.url, wb.query, wb.param, opts)
+	if display != nil {
+		display.finish()
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 	}
+	if snapDir != "" {
+		if err := writeSnapshots(snapDir, provResults.Provenance); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
 	fmt.Println(provResults)
 }
 
+// writeSnapshots writes the snapshot of each entity to the directory,
+// named after the entity and revision, e.g. Q12345-1419131078.json, and
+// removes it from the provenance output.
+func writeSnapshots(dir string, provs []wikiprov.Provenance) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for idx, prov := range provs {
+		if prov.Snapshot == nil {
+			continue
+		}
+		name := filepath.Join(dir, fmt.Sprintf("%s-%d.json", prov.Title, prov.Revision))
+		if err := ioutil.WriteFile(name, prov.Snapshot, 0o644); err != nil {
+			return err
+		}
+		provs[idx].Snapshot = nil
+	}
+	return nil
+}
+
 func isPipeInput() bool {
 	info, err := os.Stdin.Stat()
 	if err != nil {
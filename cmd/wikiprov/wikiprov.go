@@ -5,38 +5,167 @@ package main
 // by this app.
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
+	"github.com/ross-spencer/wikiprov/internal/flags"
 	"github.com/ross-spencer/wikiprov/pkg/wikiprov"
 )
 
 var (
-	demo    bool
-	history int
-	qid     string
-	vers    bool
+	demo        bool
+	qid         string
+	refresh     string
+	vers        bool
+	optionFlags flags.Options
+	clientFlags flags.Client
 )
 
 func init() {
 	flag.BoolVar(&demo, "demo", false, "Run the tool with a demo value and all provenance")
-	flag.IntVar(&history, "history", 10, "length of history to return")
+	flag.IntVar(&optionFlags.History, "history", 10, "length of history to return")
 	flag.StringVar(&qid, "qid", "", "QID to look up provenance for")
+	flag.StringVar(&optionFlags.Properties, "properties", "", "comma separated property IDs to limit history to, e.g. P2748,P4152")
+	flag.IntVar(&clientFlags.MaxLag, "maxlag", 5, "maxlag in seconds to send to Wikibase, 0 to disable")
+	flag.IntVar(&clientFlags.Retries, "retries", wikiprov.DefaultRetryPolicy.MaxAttempts, "maximum attempts for each request")
+	flag.BoolVar(&clientFlags.RenderHistory, "strings", false, "include history as pre-formatted strings")
+	flag.BoolVar(&optionFlags.FollowRedirects, "follow-redirects", false, "return provenance of the item a redirected, e.g. merged, QID points to")
+	flag.BoolVar(&optionFlags.Creation, "created", false, "include the date the QID was created and its creator")
+	flag.StringVar(&optionFlags.RevisionProperties, "revprops", "", "comma separated revision properties to request, e.g. ids,user,comment,timestamp,sha1,tags,size")
+	flag.StringVar(&clientFlags.Proxy, "proxy", "", "proxy URL to connect through, read from HTTPS_PROXY etc. if not set")
+	flag.StringVar(&clientFlags.CAFile, "cacert", "", "PEM file of certificate authorities to trust alongside the system's")
+	flag.StringVar(&clientFlags.CacheDir, "cache", "", "directory to cache provenance in between runs, checked against each entity's latest revision")
+	flag.StringVar(&refresh, "refresh", "", "JSON file of provenance previously output to add newer revisions to")
+	flag.StringVar(&optionFlags.AsOf, "asof", "", "return provenance as it was at a date or time, e.g. 2023-01-01 or 2023-01-01T12:00:00Z")
+	flag.StringVar(&optionFlags.Since, "since", "", "only return revisions made at or after a date or time, e.g. 2023-01-01")
+	flag.StringVar(&optionFlags.Until, "until", "", "only return revisions made at or before a date or time, e.g. 2023-03-31T23:59:59Z")
+	flag.StringVar(&optionFlags.Users, "users", "", "comma separated users to limit history to")
+	flag.StringVar(&optionFlags.ExcludeUsers, "exclude-users", "", "comma separated users to leave out of history")
+	flag.BoolVar(&optionFlags.ExcludeBots, "exclude-bots", false, "leave revisions made by bots out of history")
+	flag.BoolVar(&optionFlags.ExcludeAnonymous, "exclude-anonymous", false, "leave revisions made by anonymous, i.e. IP address, editors out of history")
+	flag.BoolVar(&optionFlags.Snapshot, "snapshot", false, "include the JSON of the entity at the revision provenance describes")
+	flag.DurationVar(&clientFlags.Timeout, "timeout", wikiprov.DefaultTransportConfig.ResponseHeaderTimeout, "time to wait for a server to respond to each request")
 	flag.BoolVar(&vers, "version", false, "Return version")
 }
 
+// refreshFile adds the revisions made since the provenance stored in
+// the file was retrieved to it, writing the refreshed provenance back
+// to the file and to stdout. The history stored is only capped if
+// -history is given.
+func refreshFile(path string, opts wikiprov.Options) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var stored wikiprov.Provenance
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("cannot read provenance from: '%s': %w", path, err)
+	}
+	opts.History = 0
+	flag.Visit(func(set *flag.Flag) {
+		if set.Name == "history" {
+			opts.History = optionFlags.History
+		}
+	})
+	prov, added, err := wikiprov.RefreshProvenance(context.Background(), stored, opts)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that the stored provenance
+	// isn't lost if it cannot be written.
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "wikiprov-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintln(tmp, prov); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "new revisions: %d\n", added)
+	fmt.Println(prov)
+	return nil
+}
+
+// verifyFiles verifies the fixity of the revisions described by the
+// provenance stored in each file against Wikibase, reporting each
+// revision on stdout. It returns false if any revision cannot be
+// verified.
+func verifyFiles(paths []string) bool {
+	verified := true
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			verified = false
+			continue
+		}
+		var stored wikiprov.Provenance
+		if err := json.Unmarshal(data, &stored); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot read provenance from: '%s': %s\n", path, err)
+			verified = false
+			continue
+		}
+		verifications, err := wikiprov.VerifyProvenance(context.Background(), stored)
+		for _, verification := range verifications {
+			if verification.Verified {
+				fmt.Printf("%s %d: verified\n", verification.Title, verification.Revision)
+				continue
+			}
+			fmt.Printf("%s %d: FAILED: %s\n", verification.Title, verification.Revision, verification.Reason)
+		}
+		if err != nil {
+			if !errors.Is(err, wikiprov.ErrFixity) {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			}
+			verified = false
+		}
+	}
+	return verified
+}
+
 func main() {
 
 	flag.Parse()
+	verify := flag.Arg(0) == "verify"
+	if verify {
+		// Options may follow the mode, e.g. verify -cacert ca.pem FILE.
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
 	if vers {
 		fmt.Fprintf(os.Stderr, "%s \n", wikiprov.Version())
 		os.Exit(0)
-	} else if flag.NFlag() == 0 {
+	} else if flag.NFlag() == 0 && !verify {
 		fmt.Fprintln(os.Stderr, "wikiprov: return info about a QID from Wikidata")
 		fmt.Fprintln(os.Stderr, "usage: wikiprov <QID e.g. Q27229608> {options}              ")
 		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-history] ...")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-properties] ...")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-strings]   ")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-follow-redirects]")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-created]   ")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-revprops] ...")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-proxy] [-cacert] ...")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-cache] ...  ")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-refresh] ...")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-asof] ...   ")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-since] [-until] ...")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-users] [-exclude-users] ...")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-exclude-bots] [-exclude-anonymous]")
+		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-snapshot]  ")
 		fmt.Fprintln(os.Stderr, "                                     OPTIONAL: [-version]   ")
+		fmt.Fprintln(os.Stderr, "       wikiprov verify <provenance JSON FILE> ...             ")
 		fmt.Fprintln(os.Stderr, "")
 		fmt.Fprintln(os.Stderr, "output: [JSON]   {wikidataProvenace}")
 		fmt.Fprintf(os.Stderr, "output: [STRING] '%s ...'\n\n", wikiprov.Version())
@@ -44,6 +173,22 @@ func main() {
 		os.Exit(0)
 	}
 
+	if err := clientFlags.Configure(wikiprov.DefaultClient()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if verify {
+		if flag.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "please provide provenance files to verify...")
+			os.Exit(1)
+		}
+		if !verifyFiles(flag.Args()) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	if demo {
 		var demoQID = "Q49300657"
 		res, _ := wikiprov.GetWikidataProvenance(demoQID, 10)
@@ -51,15 +196,33 @@ func main() {
 		return
 	}
 
+	opts, err := optionFlags.Parse()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if refresh != "" {
+		if err := refreshFile(refresh, opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if qid == "" {
 		fmt.Println("please provide a QID to lookup...")
 		return
 	}
 
-	res, err := wikiprov.GetWikidataProvenance(qid, history)
+	res, err := wikiprov.GetProvenance(context.Background(), qid, opts)
 	if err != nil {
 		fmt.Println(err)
-		return
+		// Missing entities and provenance whose snapshot couldn't be
+		// retrieved are still output.
+		if !res.Missing && res.Revision == 0 {
+			return
+		}
 	}
 
 	fmt.Println(res)
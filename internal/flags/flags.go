@@ -0,0 +1,142 @@
+// Package flags parses the options given to the applications built on
+// wikiprov, e.g. wikiprov and spargo, so that each understands them the
+// same way.
+package flags
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ross-spencer/wikiprov/pkg/wikiprov"
+)
+
+// dateLayout is the layout of a date given without a time.
+const dateLayout = "2006-01-02"
+
+// ParseTime parses a time given as text, e.g. the time to return
+// provenance as of. Either a date, meaning the start of that day in
+// UTC, or an RFC3339 timestamp can be given.
+func ParseTime(value string) (time.Time, error) {
+	if date, err := time.Parse(dateLayout, value); err == nil {
+		return date, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse time, expected e.g. 2023-01-01 or 2023-01-01T12:00:00Z: '%s'", value)
+	}
+	return parsed, nil
+}
+
+// SplitList splits a comma separated list given as text into its
+// values, leaving out any that are empty.
+func SplitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Options are wikiprov.Options given as text. Lists are comma separated
+// and times are parsed with ParseTime.
+type Options struct {
+	History            int
+	Properties         string
+	FollowRedirects    bool
+	Creation           bool
+	RevisionProperties string
+	AsOf               string
+	Since              string
+	Until              string
+	Users              string
+	ExcludeUsers       string
+	ExcludeBots        bool
+	ExcludeAnonymous   bool
+	Snapshot           bool
+}
+
+// Parse returns the wikiprov.Options the flags describe.
+func (flags Options) Parse() (wikiprov.Options, error) {
+	opts := wikiprov.Options{
+		History:            flags.History,
+		Properties:         SplitList(flags.Properties),
+		FollowRedirects:    flags.FollowRedirects,
+		Creation:           flags.Creation,
+		RevisionProperties: SplitList(flags.RevisionProperties),
+		Users:              SplitList(flags.Users),
+		ExcludeUsers:       SplitList(flags.ExcludeUsers),
+		ExcludeBots:        flags.ExcludeBots,
+		ExcludeAnonymous:   flags.ExcludeAnonymous,
+		Snapshot:           flags.Snapshot,
+	}
+	times := []struct {
+		value string
+		time  *time.Time
+	}{
+		{flags.AsOf, &opts.AsOf},
+		{flags.Since, &opts.Since},
+		{flags.Until, &opts.Until},
+	}
+	for _, given := range times {
+		if given.value == "" {
+			continue
+		}
+		parsed, err := ParseTime(given.value)
+		if err != nil {
+			return wikiprov.Options{}, err
+		}
+		*given.time = parsed
+	}
+	return opts, nil
+}
+
+// Client is the configuration of a wikiprov.Client given as text.
+// Retries, Proxy, CAFile, Timeout, and CacheDir are left as the client
+// has them when they aren't set. MaxLag is always set as zero disables
+// it.
+type Client struct {
+	RenderHistory bool
+	MaxLag        int
+	Retries       int
+	Proxy         string
+	CAFile        string
+	Timeout       time.Duration
+	CacheDir      string
+}
+
+// Configure configures the client from the flags. A new HTTP transport
+// is only created, from wikiprov.DefaultTransportConfig, when the
+// proxy, certificate authorities, or timeout are given.
+func (flags Client) Configure(client *wikiprov.Client) error {
+	client.RenderHistory = flags.RenderHistory
+	client.MaxLag = flags.MaxLag
+	if flags.Retries > 0 {
+		client.Retry.MaxAttempts = flags.Retries
+	}
+	if flags.Proxy != "" || flags.CAFile != "" || flags.Timeout > 0 {
+		config := wikiprov.DefaultTransportConfig
+		config.Proxy = flags.Proxy
+		config.CAFile = flags.CAFile
+		if flags.Timeout > 0 {
+			config.ResponseHeaderTimeout = flags.Timeout
+		}
+		httpClient, err := config.NewHTTPClient()
+		if err != nil {
+			return err
+		}
+		client.HTTPClient = httpClient
+	}
+	if flags.CacheDir == "" {
+		return nil
+	}
+	cache, err := wikiprov.NewFileCache(flags.CacheDir)
+	if err != nil {
+		return err
+	}
+	client.Cache = cache
+	return nil
+}
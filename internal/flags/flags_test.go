@@ -0,0 +1,71 @@
+package flags
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ross-spencer/wikiprov/pkg/wikiprov"
+)
+
+// TestOptions ensures that options given as text are parsed.
+func TestOptions(t *testing.T) {
+	if parsed, err := ParseTime("2023-01-01"); err != nil || !parsed.Equal(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected a date to be the start of the day in UTC, received: '%s' (%v)", parsed, err)
+	}
+	if _, err := ParseTime("01/01/2023"); err == nil {
+		t.Errorf("Expected an error parsing an unsupported time")
+	}
+	if values := SplitList(" P31, ,P279,"); !reflect.DeepEqual(values, []string{"P31", "P279"}) {
+		t.Errorf("List split incorrectly, received: %v", values)
+	}
+
+	flags := Options{
+		History:      5,
+		Properties:   "P2748,P4152",
+		AsOf:         "2023-03-01",
+		Until:        "2023-01-31T23:59:59Z",
+		ExcludeUsers: "Winston Smith",
+		ExcludeBots:  true,
+	}
+	opts, err := flags.Parse()
+	if err != nil {
+		t.Fatalf("Unexpected error parsing options: %s", err)
+	}
+	expected := wikiprov.Options{
+		History:      5,
+		Properties:   []string{"P2748", "P4152"},
+		AsOf:         time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+		Until:        time.Date(2023, 1, 31, 23, 59, 59, 0, time.UTC),
+		ExcludeUsers: []string{"Winston Smith"},
+		ExcludeBots:  true,
+	}
+	if !reflect.DeepEqual(opts, expected) {
+		t.Errorf("Options parsed incorrectly, received: %+v, expected: %+v", opts, expected)
+	}
+	flags.Since = "yesterday"
+	if _, err := flags.Parse(); err == nil {
+		t.Errorf("Expected an error parsing an unsupported time")
+	}
+}
+
+// TestClient ensures that a client is configured from flags given as
+// text and that its defaults are kept where flags aren't set.
+func TestClient(t *testing.T) {
+	client := wikiprov.NewClient("https://wikibase.example.com/")
+	shared := client.HTTPClient
+	if err := (Client{}).Configure(client); err != nil {
+		t.Fatalf("Unexpected error configuring client: %s", err)
+	}
+	if client.Retry.MaxAttempts != wikiprov.DefaultRetryPolicy.MaxAttempts || client.HTTPClient != shared || client.Cache != nil {
+		t.Errorf("Expected the client's defaults to be kept: %+v", client)
+	}
+
+	config := Client{MaxLag: 2, Retries: 1, Timeout: time.Second, CacheDir: t.TempDir()}
+	if err := config.Configure(client); err != nil {
+		t.Fatalf("Unexpected error configuring client: %s", err)
+	}
+	if client.MaxLag != 2 || client.Retry.MaxAttempts != 1 || client.Cache == nil || client.HTTPClient == shared {
+		t.Errorf("Client configured incorrectly: %+v", client)
+	}
+}
@@ -0,0 +1,187 @@
+package spargo
+
+// Adaptive concurrency for the provenance workers. Rather than working
+// out by trial and error how many threads a Wikibase will tolerate, the
+// number of requests in flight is adjusted as provenance is retrieved
+// using additive increase, multiplicative decrease (AIMD): concurrency
+// grows by one each time a full round of fast, successful responses is
+// received, and is halved when Wikibase tells us to slow down, e.g.
+// with a 429 or 503 status or a maxlag error.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ross-spencer/wikiprov/pkg/wikiprov"
+)
+
+// maxAdaptiveChannels caps the concurrency adaptive workers can grow
+// to.
+var maxAdaptiveChannels = 50
+
+// fastResponse is the latency under which a response is considered
+// fast enough to increase concurrency.
+var fastResponse = 2 * time.Second
+
+// throttleCooldown is the time after concurrency is decreased during
+// which further signals to slow down are ignored. Requests in flight
+// when Wikibase starts to throttle us are likely to be throttled too
+// and shouldn't each halve concurrency.
+var throttleCooldown = 1 * time.Second
+
+// ConcurrencyChange describes a change in the number of provenance
+// requests in flight. Elapsed is the time since provenance retrieval
+// started.
+type ConcurrencyChange struct {
+	Elapsed     time.Duration
+	Concurrency int
+	Reason      string
+}
+
+// String renders the change for debug output.
+func (change ConcurrencyChange) String() string {
+	return fmt.Sprintf(
+		"concurrency: %d at %s (%s)",
+		change.Concurrency,
+		change.Elapsed.Round(time.Millisecond),
+		change.Reason,
+	)
+}
+
+// adaptiveConcurrency limits the number of workers requesting
+// provenance at once, adjusting the limit as responses are received.
+type adaptiveConcurrency struct {
+	mutex        sync.Mutex
+	cond         *sync.Cond
+	limit        int
+	max          int
+	active       int
+	successes    int
+	start        time.Time
+	lastDecrease time.Time
+	timeline     []ConcurrencyChange
+	debug        io.Writer
+}
+
+// newAdaptiveConcurrency returns an adaptiveConcurrency starting with a
+// single worker that can grow to max. Changes in concurrency are
+// written to debug if it isn't nil.
+func newAdaptiveConcurrency(max int, debug io.Writer) *adaptiveConcurrency {
+	if max < 1 {
+		max = 1
+	}
+	adaptive := &adaptiveConcurrency{
+		limit: 1,
+		max:   max,
+		start: time.Now(),
+		debug: debug,
+	}
+	adaptive.cond = sync.NewCond(&adaptive.mutex)
+	adaptive.record("start")
+	return adaptive
+}
+
+// acquire blocks until the worker can make a request. The context's
+// error is returned if it is done first.
+func (adaptive *adaptiveConcurrency) acquire(ctx context.Context) error {
+	if adaptive == nil {
+		return ctx.Err()
+	}
+	// Wake waiting workers if the context is done so that they can
+	// exit.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			adaptive.mutex.Lock()
+			adaptive.cond.Broadcast()
+			adaptive.mutex.Unlock()
+		case <-stop:
+		}
+	}()
+	adaptive.mutex.Lock()
+	defer adaptive.mutex.Unlock()
+	for adaptive.active >= adaptive.limit {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		adaptive.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	adaptive.active++
+	return nil
+}
+
+// release tells the limiter that a request has finished, how long it
+// took, and whether it succeeded. Concurrency is increased once a full
+// round of requests at the current limit has been fast and successful.
+func (adaptive *adaptiveConcurrency) release(latency time.Duration, success bool) {
+	if adaptive == nil {
+		return
+	}
+	adaptive.mutex.Lock()
+	defer adaptive.mutex.Unlock()
+	adaptive.active--
+	defer adaptive.cond.Broadcast()
+	if !success || latency >= fastResponse {
+		adaptive.successes = 0
+		return
+	}
+	adaptive.successes++
+	if adaptive.successes >= adaptive.limit && adaptive.limit < adaptive.max {
+		adaptive.successes = 0
+		adaptive.limit++
+		adaptive.record("increase after fast responses")
+	}
+}
+
+// throttled halves concurrency when Wikibase asks us to slow down.
+func (adaptive *adaptiveConcurrency) throttled(err error) {
+	adaptive.mutex.Lock()
+	defer adaptive.mutex.Unlock()
+	if time.Since(adaptive.lastDecrease) < throttleCooldown {
+		return
+	}
+	adaptive.lastDecrease = time.Now()
+	adaptive.successes = 0
+	if adaptive.limit == 1 {
+		return
+	}
+	adaptive.limit /= 2
+	adaptive.record(fmt.Sprintf("decrease after: %s", wikiprov.ErrorClass(err)))
+}
+
+// record adds the current concurrency to the timeline. The caller
+// must hold the mutex.
+func (adaptive *adaptiveConcurrency) record(reason string) {
+	change := ConcurrencyChange{
+		Elapsed:     time.Since(adaptive.start),
+		Concurrency: adaptive.limit,
+		Reason:      reason,
+	}
+	adaptive.timeline = append(adaptive.timeline, change)
+	if adaptive.debug != nil {
+		fmt.Fprintln(adaptive.debug, change)
+	}
+}
+
+// observe returns a copy of the client that tells the limiter when
+// Wikibase asks us to slow down, as well as any function the client
+// already has to observe it.
+func (adaptive *adaptiveConcurrency) observe(client *wikiprov.Client) *wikiprov.Client {
+	observed := *client
+	onThrottle := client.OnThrottle
+	observed.OnThrottle = func(err error) {
+		adaptive.throttled(err)
+		if onThrottle != nil {
+			onThrottle(err)
+		}
+	}
+	return &observed
+}
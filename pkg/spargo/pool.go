@@ -0,0 +1,181 @@
+package spargo
+
+// The pool of workers retrieving provenance from Wikibase. Entities are
+// split into batches, see batchQIDs, which are handed to the workers in
+// order. Each worker writes the provenance it retrieves into its place
+// in the results so that they are returned in the order the entities
+// were given, however long each request takes.
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ross-spencer/wikiprov/pkg/wikiprov"
+)
+
+// Progress describes how much of the provenance requested has been
+// retrieved. Done counts the entities that have been requested, of
+// which Errors could not be retrieved, out of Total.
+type Progress struct {
+	Done   int
+	Total  int
+	Errors int
+}
+
+// provenancePool configures the workers retrieving provenance.
+type provenancePool struct {
+	// client retrieves provenance from Wikibase.
+	client *wikiprov.Client
+	// opts are used for each request for provenance.
+	opts wikiprov.Options
+	// threads is the number of workers.
+	threads int
+	// adaptive, if set, limits how many of the workers make requests
+	// at once.
+	adaptive *adaptiveConcurrency
+	// progress, if set, is called before provenance is requested and
+	// each time a batch has been retrieved. It is called by one worker
+	// at a time.
+	progress func(Progress)
+}
+
+// getProvThreaded goes out to Wikibase and collects the provenance
+// associated with a record. The pool's threads limit the number of
+// requests made at once to provide some level of throttling and to also
+// increase performance of this. For ~5000 records this can take 15
+// minutes without concurrency.
+//
+// Where only the latest revision of each record is needed, records are
+// requested from Wikibase in batches, cutting the number of requests
+// by up to wikiprov.MaxBatchSize times.
+//
+// Provenance is returned in the same order as the QIDs. If the context
+// is done, no new work is started and the provenance collected so far
+// is returned in order with the context's error.
+func getProvThreaded(ctx context.Context, qids []string, pool provenancePool) ([]wikiprov.Provenance, error) {
+	batches := batchQIDs(qids, pool.opts)
+	results := make([][]wikiprov.Provenance, len(batches))
+
+	threads := pool.threads
+	if threads < 1 {
+		threads = 1
+	}
+	if threads > len(batches) {
+		threads = len(batches)
+	}
+
+	// jobs hands each batch to the next free worker. It is unbuffered
+	// so that no more work is queued than the workers can take.
+	jobs := make(chan int)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	progress := Progress{Total: len(qids)}
+	if pool.progress != nil {
+		// Tell the caller how much work there is before any is done.
+		pool.progress(progress)
+	}
+	for worker := 0; worker < threads; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				if pool.adaptive.acquire(ctx) != nil {
+					// Cancelled while waiting, leave the batch
+					// unfinished.
+					continue
+				}
+				// Retrieve the provenance information from Wikibase.
+				start := time.Now()
+				provs := getProvenance(ctx, pool.client, batches[idx], pool.opts)
+				pool.adaptive.release(time.Since(start), succeeded(provs))
+				if ctx.Err() != nil {
+					// Results from a cancelled request are incomplete.
+					continue
+				}
+				mutex.Lock()
+				results[idx] = provs
+				progress.Done += len(provs)
+				progress.Errors += countErrors(provs)
+				if pool.progress != nil {
+					pool.progress(progress)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for idx := range batches {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- idx:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	provCache := make([]wikiprov.Provenance, 0, len(qids))
+	for _, provs := range results {
+		provCache = append(provCache, provs...)
+	}
+	return provCache, ctx.Err()
+}
+
+// batchQIDs splits the QIDs into the batches they will be requested
+// from Wikibase in. Each QID is requested on its own unless the options
+// allow provenance to be retrieved in batches.
+func batchQIDs(qids []string, opts wikiprov.Options) [][]string {
+	size := 1
+	if opts.Batchable() {
+		size = wikiprov.MaxBatchSize
+	}
+	var batches [][]string
+	for start := 0; start < len(qids); start += size {
+		end := start + size
+		if end > len(qids) {
+			end = len(qids)
+		}
+		batches = append(batches, qids[start:end])
+	}
+	return batches
+}
+
+// succeeded tells the caller whether Wikibase answered the request for
+// every record. Records that don't exist are an answer.
+func succeeded(provs []wikiprov.Provenance) bool {
+	for _, prov := range provs {
+		if prov.Error != nil && !errors.Is(prov.Error, wikiprov.ErrNotFound) {
+			return false
+		}
+	}
+	return true
+}
+
+// countErrors returns the number of records whose provenance could not
+// be retrieved.
+func countErrors(provs []wikiprov.Provenance) int {
+	count := 0
+	for _, prov := range provs {
+		if prov.Error != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// getProvenance is a helper which is used to call wikiprov's primary
+// function collecting provenance for a batch of records from the
+// underlying Wikibase implementation. Where provenance cannot be
+// retrieved for a record its title is its QID and its error tells us
+// why so that we can handle it upstream.
+func getProvenance(
+	ctx context.Context,
+	client *wikiprov.Client,
+	qids []string,
+	opts wikiprov.Options,
+) []wikiprov.Provenance {
+	return client.GetProvenanceBatch(ctx, qids, opts)
+}
@@ -9,12 +9,15 @@ package spargo
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"path"
 	"strings"
-	"sync"
 
 	"github.com/ross-spencer/spargo/pkg/spargo"
 	"github.com/ross-spencer/wikiprov/pkg/wikiprov"
@@ -47,6 +50,11 @@ type WikiProv struct {
 	Head       map[string]interface{} `json:"head"`
 	Binding    `json:"results"`
 	Provenance []wikiprov.Provenance `json:"provenance,omitempty"`
+	// ProvenanceErrors lists the entities for which provenance could
+	// not be retrieved.
+	ProvenanceErrors []ProvenanceError `json:"provenance_errors,omitempty"`
+	// ProvenanceSummary counts the outcome of retrieving provenance.
+	ProvenanceSummary *ProvenanceSummary `json:"provenance_summary,omitempty"`
 }
 
 // maxChannels determines the number of channels to use in requests to
@@ -83,29 +91,165 @@ func SPARQLWithProv(
 	lenHistory int,
 	threads int,
 ) (WikiProv, error) {
+	return SPARQLWithProvContext(
+		context.Background(),
+		endpoint,
+		queryString,
+		param,
+		lenHistory,
+		threads,
+	)
+}
+
+// SPARQLWithProvContext is SPARQLWithProv with a context that can be
+// used to cancel the query and the retrieval of provenance, or set a
+// deadline for them. If the context is done while provenance is being
+// retrieved the results are returned with the provenance collected so
+// far alongside the context's error.
+func SPARQLWithProvContext(
+	ctx context.Context,
+	endpoint string,
+	queryString string,
+	param string,
+	lenHistory int,
+	threads int,
+) (WikiProv, error) {
+	opts := Options{Threads: threads}
+	opts.History = lenHistory
+	return SPARQLWithProvOptions(ctx, endpoint, queryString, param, opts)
+}
+
+// Options configure the retrieval of provenance for SPARQL results.
+// The embedded wikiprov.Options are used for each request for
+// provenance.
+type Options struct {
+	wikiprov.Options
+	// Threads is the number of go routines used to retrieve
+	// provenance, up to a maximum of maxChannels.
+	Threads int
+	// Client is the Wikibase client provenance is retrieved with. Its
+	// HTTP transport is also used to query the SPARQL endpoint so that
+	// both share the same proxy, certificate authorities, and
+	// connection pool. The default wikiprov client is used when it is
+	// nil.
+	Client *wikiprov.Client
+	// Rate limits the requests made to Wikibase to the given number per
+	// second across all threads, allowing bursts of up to Burst
+	// requests. The client's own rate limiter is used when it is zero.
+	Rate  float64
+	Burst int
+	// Adaptive adjusts the number of threads requesting provenance as
+	// it is retrieved, starting with one and growing while Wikibase
+	// responds quickly, up to Threads, and halving when it asks us to
+	// slow down. Threads may be up to maxAdaptiveChannels.
+	Adaptive bool
+	// Debug receives debug output, e.g. changes in concurrency when
+	// Adaptive is set.
+	Debug io.Writer
+	// Progress is called before provenance is requested and each time
+	// it has been retrieved for more of the entities in the results.
+	Progress func(Progress)
+}
+
+// client returns the Wikibase client to retrieve provenance with. When
+// a rate is given a copy of the client is returned with a rate limiter
+// shared by all of the requests for provenance.
+func (opts Options) client() *wikiprov.Client {
+	client := opts.Client
+	if client == nil {
+		client = wikiprov.DefaultClient()
+	}
+	if opts.Rate <= 0 {
+		return client
+	}
+	limited := *client
+	limited.RateLimiter = wikiprov.NewRateLimiter(opts.Rate, opts.Burst)
+	return &limited
+}
+
+// sparqlTransport returns the HTTP transport used to query the SPARQL
+// endpoint, shared with the Wikibase client.
+func sparqlTransport(client *wikiprov.Client) http.RoundTripper {
+	if client.HTTPClient != nil && client.HTTPClient.Transport != nil {
+		return client.HTTPClient.Transport
+	}
+	return http.DefaultTransport
+}
+
+// SPARQLWithProvOptions is SPARQLWithProvContext configured using
+// Options, e.g. to limit the provenance returned to revisions that
+// reference particular properties.
+func SPARQLWithProvOptions(
+	ctx context.Context,
+	endpoint string,
+	queryString string,
+	param string,
+	opts Options,
+) (WikiProv, error) {
+	client := opts.client()
 	sparqlMe := SPARQLClient{}
+	sparqlMe.Client = &http.Client{
+		Transport: contextTransport{ctx: ctx, base: sparqlTransport(client)},
+	}
 	sparqlMe.ClientInit(endpoint, queryString)
 	res, err := sparqlMe.SPARQLGo()
 	if err != nil {
+		if ctx.Err() != nil {
+			return WikiProv{}, ctx.Err()
+		}
 		return WikiProv{}, err
 	}
 	provResults := WikiProv{}
 	provResults.Head = res.Head
 	provResults.Binding = res.Results
-	if param == "" || lenHistory < 1 {
+	if param == "" || (opts.History < 1 && opts.History != wikiprov.FullHistory) {
 		return provResults, nil
 	}
 	param = fixKey(param)
-	if threads > maxChannels {
+	threads := opts.Threads
+	var adaptive *adaptiveConcurrency
+	if opts.Adaptive {
+		if threads < 1 || threads > maxAdaptiveChannels {
+			threads = maxAdaptiveChannels
+		}
+		adaptive = newAdaptiveConcurrency(threads, opts.Debug)
+		client = adaptive.observe(client)
+	} else if threads > maxChannels {
 		threads = maxChannels
 	}
-	err = provResults.attachProvenance(param, lenHistory, threads)
+	pool := provenancePool{
+		client:   client,
+		opts:     opts.Options,
+		threads:  threads,
+		adaptive: adaptive,
+		progress: opts.Progress,
+	}
+	err = provResults.attachProvenance(ctx, param, pool)
 	if err != nil {
+		if ctx.Err() != nil {
+			return provResults, ctx.Err()
+		}
+		if errors.Is(err, ErrProvAttach) {
+			// Gaps in provenance are described in the results.
+			return provResults, err
+		}
 		return WikiProv{}, err
 	}
 	return provResults, nil
 }
 
+// contextTransport attaches a context to the requests made by the
+// generic spargo package which doesn't accept one itself.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper for contextTransport.
+func (transport contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return transport.base.RoundTrip(req.WithContext(transport.ctx))
+}
+
 // validateIRI will attempt to perform some basic validation on IRI's
 // we're trying to retrieve provenance information for. We need to build
 // up a set of rules.
@@ -158,39 +302,50 @@ func getQID(iri string) (string, error) {
 var ErrProvAttach error = fmt.Errorf("warning: there were errors retrieving provenance from Wikibase API")
 
 // AttachProvenance will attach WikiBase provenance to SPARQL results
-// from Wikidata.
+// from Wikidata. Entities for which provenance could not be retrieved
+// are listed in the results' provenance errors and ErrProvAttach is
+// returned so the caller knows to look for them.
 func (sparql *WikiProv) attachProvenance(
+	ctx context.Context,
 	sparqlParam string,
-	lenHistory int,
-	threads int,
+	pool provenancePool,
 ) error {
-	var qids map[string]bool
-	qids = make(map[string]bool)
+	// uniqueQIDs are kept in the order they first appear in the
+	// results so that provenance is returned in a predictable order.
+	var qids map[string]string
+	qids = make(map[string]string)
+	var uniqueQIDs []string
+	notApplicable := 0
 	for _, value := range sparql.Bindings {
 		wikidataIRI := value[sparqlParam].Value
 		if !validateIRI(wikidataIRI) {
+			notApplicable++
 			continue
 		}
 		qid, err := getQID(wikidataIRI)
 		if err != nil {
 			return err
 		}
-		qids[qid] = false
+		if qid == "" {
+			notApplicable++
+			continue
+		}
+		if _, ok := qids[qid]; !ok {
+			qids[qid] = wikidataIRI
+			uniqueQIDs = append(uniqueQIDs, qid)
+		}
 	}
 	if len(qids) < 1 {
 		return fmt.Errorf("No results returned from given sparqlParam: %s", sparqlParam)
 	}
 
-	var uniqueQIDs []string
-	for sparqlParam := range qids {
-		uniqueQIDs = append(uniqueQIDs, sparqlParam)
-	}
-
-	preProvCache := getProvThreaded(uniqueQIDs, lenHistory, threads)
+	preProvCache, ctxErr := getProvThreaded(ctx, uniqueQIDs, pool)
 	provCache := []wikiprov.Provenance{}
+	provErrors := []ProvenanceError{}
 
 	for _, value := range preProvCache {
 		if value.Error != nil {
+			provErrors = append(provErrors, newProvenanceError(value, qids[value.Title]))
 			continue
 		}
 		if value.Title == "" && value.Revision == 0 && value.Permalink == "" {
@@ -198,75 +353,79 @@ func (sparql *WikiProv) attachProvenance(
 		}
 		provCache = append(provCache, value)
 	}
-	if len(provCache) == 0 && lenHistory > 0 {
+
+	sparql.Provenance = provCache
+	sparql.ProvenanceErrors = provErrors
+	sparql.ProvenanceSummary = &ProvenanceSummary{
+		Entities:      len(uniqueQIDs),
+		Retrieved:     len(provCache),
+		Failed:        len(provErrors),
+		NotApplicable: notApplicable,
+	}
+
+	if ctxErr != nil {
+		// Return what we have so that the caller can make use of it.
+		return ctxErr
+	}
+	if len(provCache) == 0 && pool.opts.History != 0 {
+		// The errors and summary still describe what happened to each
+		// entity.
 		return fmt.Errorf(
-			"history configured but unable to retrieve history from Wikibase",
+			"%w: history configured but unable to retrieve history from Wikibase for any of '%d' entities",
+			ErrProvAttach,
+			len(uniqueQIDs),
 		)
 	}
 
-	sparql.Provenance = provCache
-
-	// Check for errors so that we can warn the caller.
-	for _, prov := range provCache {
-		if prov.Error != nil {
-			return fmt.Errorf("%w: %s", ErrProvAttach, prov.Error)
-		}
+	// Warn the caller there are gaps in the provenance returned.
+	if len(provErrors) > 0 {
+		return fmt.Errorf(
+			"%w: provenance could not be retrieved for '%d' of '%d' entities",
+			ErrProvAttach,
+			len(provErrors),
+			len(uniqueQIDs),
+		)
 	}
 
 	return nil
 }
 
-// getProvThreaded goes out to Wikibase and collects the provenance
-// associated with a record. The function takes an argument that limits
-// the number of channels to be used to do work to provide some level
-// of throttling and to also increase performance of this. For ~5000
-// records this can take 15 minutes without concurrency.
-func getProvThreaded(qids []string, lenHistory int, maxChan int) []wikiprov.Provenance {
-	ch := make(chan wikiprov.Provenance)
-	var mutex sync.Mutex
-	counter := 0
-	for channels := 0; channels < maxChan; channels++ {
-		go func(ch chan wikiprov.Provenance, mutex *sync.Mutex) {
-			for {
-				mutex.Lock()
-				idx := counter
-				counter++
-				mutex.Unlock()
-				if counter > len(qids) {
-					// Finished processing, exit.
-					return
-				}
-				qid := qids[idx]
-				// Retrieve the provenance information from Wikibase.
-				prov := getProvenance(qid, lenHistory)
-				ch <- prov
-			}
-		}(ch, &mutex)
-	}
-	var provCache []wikiprov.Provenance
-	provCache = make([]wikiprov.Provenance, len(qids))
-	getData(ch, provCache)
-	return provCache
+// ProvenanceError describes an entity for which provenance could not
+// be retrieved from Wikibase.
+type ProvenanceError struct {
+	QID      string `json:"qid"`
+	IRI      string `json:"iri"`
+	Class    string `json:"class"`
+	Message  string `json:"message"`
+	Attempts int    `json:"attempts"`
+	// Deleted is set when the entity is not found because it has been
+	// deleted from Wikibase.
+	Deleted bool `json:"deleted,omitempty"`
 }
 
-// getData invokes the go routines and then adds the results to the
-// provenance array.
-func getData(ch <-chan wikiprov.Provenance, provCache []wikiprov.Provenance) {
-	for idx := 0; idx < len(provCache); idx++ {
-		provCache[idx] = <-ch
+// newProvenanceError creates a ProvenanceError from provenance that
+// was returned with an error.
+func newProvenanceError(prov wikiprov.Provenance, iri string) ProvenanceError {
+	return ProvenanceError{
+		QID:      prov.Title,
+		IRI:      iri,
+		Class:    wikiprov.ErrorClass(prov.Error),
+		Message:  prov.Error.Error(),
+		Attempts: wikiprov.ErrorAttempts(prov.Error),
+		Deleted:  prov.Deleted,
 	}
 }
 
-// getProvenance is a helper which is used to call wikiprov's primary
-// function collecting provenance for a record from the underlying
-// Wikibase implementation.
-func getProvenance(qid string, lenHistory int) wikiprov.Provenance {
-	prov, err := wikiprov.GetWikidataProvenance(qid, lenHistory)
-	if err != nil {
-		// We'll handle the error upstream.
-		prov.Error = err
-	}
-	return prov
+// ProvenanceSummary counts the outcome of attaching provenance to
+// SPARQL results. Entities is the number of unique entities provenance
+// was requested for, of which provenance was Retrieved or Failed.
+// NotApplicable is the number of results that didn't describe an
+// entity, e.g. statements or literals.
+type ProvenanceSummary struct {
+	Entities      int `json:"entities"`
+	Retrieved     int `json:"retrieved"`
+	Failed        int `json:"failed"`
+	NotApplicable int `json:"not_applicable"`
 }
 
 // String will return a summary of a Wikiprov structure as JSON.
@@ -1,10 +1,18 @@
 package spargo
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ross-spencer/wikiprov/pkg/wikiprov"
 )
@@ -23,7 +31,12 @@ func TestGetProvThreadedError(t *testing.T) {
 
 	for _, val := range errorTests {
 
-		provs := getProvThreaded(val.qids, 5, val.threads)
+		pool := provenancePool{
+			client:  wikiprov.DefaultClient(),
+			opts:    wikiprov.Options{History: 5},
+			threads: val.threads,
+		}
+		provs, _ := getProvThreaded(context.Background(), val.qids, pool)
 
 		if len(provs) != len(val.qids) {
 			t.Errorf("Despite testing an error condition results returned are not correct length. Got '%d', expected '%d'",
@@ -61,7 +74,12 @@ func TestGetProvThreaded(t *testing.T) {
 		// threads etc. If there is an opportunity then these tests can
 		// be expanded to be more varied.
 
-		provs := getProvThreaded(test.qids, 5, 10)
+		pool := provenancePool{
+			client:  wikiprov.DefaultClient(),
+			opts:    wikiprov.Options{History: 5},
+			threads: 10,
+		}
+		provs, _ := getProvThreaded(context.Background(), test.qids, pool)
 
 		if len(provs) != len(test.qids) {
 			t.Errorf("Results length from getProvThreaded: '%d' not what was expected: '%d'",
@@ -77,8 +95,7 @@ func TestGetProvThreaded(t *testing.T) {
 		testProvOutput.Revision = 2600
 		testProvOutput.Modified = "2020-08-31T23:13:00Z"
 		testProvOutput.Permalink = "https://www.wikidata.org/w/index.php?oldid=2600&title=Q12345"
-		testProvOutput.History = append(testProvOutput.History, "2020-08-31T23:13:00Z (oldid: 2600): 'Emmanuel Goldstein' edited: 'edit comment #1'")
-		testProvOutput.History = append(testProvOutput.History, "2020-08-01T23:13:00Z (oldid: 1000): 'Robert Smith' edited: 'edit comment #2'")
+		testProvOutput.Revisions = testRevisions
 		testProvOutput.Error = nil
 
 		if !reflect.DeepEqual(provs[0], testProvOutput) {
@@ -127,12 +144,20 @@ func TestSPARQLWithProvError(t *testing.T) {
 
 	prov, err := SPARQLWithProv(sparqlTestServer.URL, "testQuery", "uri", lenResults, threads)
 
-	if !reflect.DeepEqual(prov, WikiProv{}) {
-		t.Errorf("Expected an empty WikiProv{} struct to be returned, returned: '%s'", prov)
+	if !errors.Is(err, ErrProvAttach) {
+		t.Errorf("anticipating ErrProvAttach from SPARQLWithProv, received: %v", err)
 	}
 
-	if err == nil {
-		t.Errorf("anticipating an error from SPARQLWithProv, received 'nil': %s", err)
+	// The results are returned with every entity reported as failed.
+	summary := prov.ProvenanceSummary
+	if len(prov.Bindings) == 0 || summary == nil {
+		t.Fatalf("Expected the results and a provenance summary to be returned, returned: '%s'", prov)
+	}
+	if summary.Entities == 0 || summary.Failed != summary.Entities || summary.Retrieved != 0 {
+		t.Errorf("Expected every entity to be reported as failed, received: %+v", summary)
+	}
+	if len(prov.ProvenanceErrors) != summary.Failed || len(prov.Provenance) != 0 {
+		t.Errorf("Expected an error for each entity, received: %+v", prov.ProvenanceErrors)
 	}
 }
 
@@ -211,8 +236,11 @@ func TestSPARQLWithProv(t *testing.T) {
 		}))
 		defer func() { apiTestServer.Close() }()
 
-		// Replace the API test server with our custom URL.
+		// Replace the API test server with our custom URL and request
+		// the pre-formatted history strings alongside the revisions.
 		wikiprov.SetWikibaseAPIURL(apiTestServer.URL)
+		wikiprov.DefaultClient().RenderHistory = true
+		defer func() { wikiprov.DefaultClient().RenderHistory = false }()
 
 		lenResults := 2 // Unimportant as the results from the tests are deterministic.
 		threads := val
@@ -243,6 +271,7 @@ func TestSPARQLWithProv(t *testing.T) {
 		testProvOutput.Revision = 2600
 		testProvOutput.Modified = "2020-08-31T23:13:00Z"
 		testProvOutput.Permalink = "http://example.com/w/index.php?oldid=2600&title=Q12345"
+		testProvOutput.Revisions = testRevisions
 		testProvOutput.History = append(testProvOutput.History, "2020-08-31T23:13:00Z (oldid: 2600): 'Emmanuel Goldstein' edited: 'edit comment #1'")
 		testProvOutput.History = append(testProvOutput.History, "2020-08-01T23:13:00Z (oldid: 1000): 'Robert Smith' edited: 'edit comment #2'")
 		testProvOutput.Error = nil
@@ -260,3 +289,448 @@ func TestSPARQLWithProv(t *testing.T) {
 		}
 	}
 }
+
+// TestSPARQLWithProvContext ensures that a slow Wikibase doesn't hold
+// up the caller beyond the deadline they have set and that the SPARQL
+// results are still returned to them.
+func TestSPARQLWithProvContext(t *testing.T) {
+
+	sparqlTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(wikidataResultsJSON))
+	}))
+	defer func() { sparqlTestServer.Close() }()
+
+	// apiTestServer doesn't respond until the test is complete.
+	done := make(chan struct{})
+	apiTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		select {
+		case <-done:
+		case <-req.Context().Done():
+		}
+	}))
+	defer func() { apiTestServer.Close() }()
+	defer close(done)
+
+	wikiprov.SetWikibaseAPIURL(apiTestServer.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	prov, err := SPARQLWithProvContext(ctx, sparqlTestServer.URL, "testQuery", "uri", 5, 10)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context deadline to be returned, received: '%v'", err)
+	}
+
+	if len(prov.Bindings) == 0 {
+		t.Errorf("Expected SPARQL results to be returned alongside the context error")
+	}
+
+	if len(prov.Provenance) != 0 {
+		t.Errorf("Expected no provenance to be returned, received: '%d' results", len(prov.Provenance))
+	}
+}
+
+// TestSPARQLWithProvOptions ensures that the options used to retrieve
+// provenance are passed through to wikiprov, e.g. filtering history by
+// property.
+func TestSPARQLWithProvOptions(t *testing.T) {
+
+	sparqlTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(wikidataResultsJSONExampleDotCom))
+	}))
+	defer func() { sparqlTestServer.Close() }()
+
+	apiTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(attachedProvenance))
+	}))
+	defer func() { apiTestServer.Close() }()
+
+	wikiprov.SetWikibaseAPIURL(apiTestServer.URL)
+
+	opts := Options{Threads: 5}
+	opts.History = 2
+	opts.Properties = []string{"P2748"}
+	opts.Creation = true
+	opts.AsOf = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	provs, err := SPARQLWithProvOptions(context.Background(), sparqlTestServer.URL, "testQuery", "uri", opts)
+	if err != nil {
+		t.Errorf("Unexpected error '%s' from SPARQLWithProvOptions", err)
+	}
+
+	if len(provs.Provenance) != 6 {
+		t.Errorf("Expected results length '%d', but got '%d'", 6, len(provs.Provenance))
+	}
+
+	// None of the revisions in the test data reference the property so
+	// provenance is returned without history.
+	for _, prov := range provs.Provenance {
+		if prov.Revision != 2600 {
+			t.Errorf("Provenance revision '%d' is incorrect, expected: '%d'", prov.Revision, 2600)
+		}
+		if len(prov.Revisions) != 0 {
+			t.Errorf("Expected no revisions to be returned, received: '%d'", len(prov.Revisions))
+		}
+		// The test server returns the same revisions when asked for
+		// the first revision of an entity.
+		if prov.Creator != "Emmanuel Goldstein" {
+			t.Errorf("Provenance creator '%s' is incorrect, expected: '%s'", prov.Creator, "Emmanuel Goldstein")
+		}
+		if prov.AsOf != "2021-01-01T00:00:00Z" {
+			t.Errorf("Provenance as of '%s' is incorrect, expected: '%s'", prov.AsOf, "2021-01-01T00:00:00Z")
+		}
+	}
+}
+
+// TestSPARQLWithProvErrors ensures that entities for which provenance
+// cannot be retrieved are reported to the caller alongside the
+// provenance that could be.
+func TestSPARQLWithProvErrors(t *testing.T) {
+
+	sparqlTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(wikidataResultsJSONExampleDotCom))
+	}))
+	defer func() { sparqlTestServer.Close() }()
+
+	// apiTestServer fails to return provenance for a single entity.
+	const failingQID = "Q100136218"
+	apiTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.URL.Query().Get("titles"), failingQID) {
+			res.WriteHeader(404)
+			res.Write([]byte("no value"))
+			return
+		}
+		res.WriteHeader(200)
+		res.Write([]byte(attachedProvenance))
+	}))
+	defer func() { apiTestServer.Close() }()
+
+	wikiprov.SetWikibaseAPIURL(apiTestServer.URL)
+
+	provs, err := SPARQLWithProv(sparqlTestServer.URL, "testQuery", "uri", 2, 5)
+
+	if !errors.Is(err, ErrProvAttach) {
+		t.Errorf("Expected ErrProvAttach to be returned, received: '%v'", err)
+	}
+
+	if len(provs.Provenance) != 5 {
+		t.Errorf("Expected results length '%d', but got '%d'", 5, len(provs.Provenance))
+	}
+
+	expectedErrors := []ProvenanceError{
+		{
+			QID:      failingQID,
+			IRI:      "http://example.com/entity/Q100136218",
+			Class:    wikiprov.ClassStatus,
+			Message:  "retrieving provenance from Wikibase endpoint for: Q100136218: incorrect status retrieving history from Wikibase endpoint: '404': expected '200' (history len: '2')",
+			Attempts: 1,
+		},
+	}
+	if !reflect.DeepEqual(provs.ProvenanceErrors, expectedErrors) {
+		t.Errorf("Provenance errors '%+v' are incorrect, expected: '%+v'", provs.ProvenanceErrors, expectedErrors)
+	}
+
+	expectedSummary := ProvenanceSummary{Entities: 6, Retrieved: 5, Failed: 1}
+	if provs.ProvenanceSummary == nil || *provs.ProvenanceSummary != expectedSummary {
+		t.Errorf("Provenance summary '%+v' is incorrect, expected: '%+v'", provs.ProvenanceSummary, expectedSummary)
+	}
+
+	if !strings.Contains(provs.String(), "\"provenance_errors\"") {
+		t.Errorf("Provenance errors should be included in JSON output: %s", provs)
+	}
+}
+
+// latestRevisionsJSON returns the latest revision of each of the titles
+// requested from a Wikibase API.
+func latestRevisionsJSON(titles string) string {
+	var pages []string
+	for idx, title := range strings.Split(titles, "|") {
+		pages = append(pages, fmt.Sprintf(
+			`"%d": {"pageid": %d, "title": "%s", "revisions": [{"revid": 2600, "timestamp": "2020-08-31T23:13:00Z", "user": "Emmanuel Goldstein"}]}`,
+			idx+1, idx+1, strings.TrimPrefix(title, "item:"),
+		))
+	}
+	return fmt.Sprintf(`{"query": {"pages": {%s}}}`, strings.Join(pages, ","))
+}
+
+// TestSPARQLWithProvBatched ensures that when only the latest revision
+// of each entity is needed provenance is requested in batches.
+func TestSPARQLWithProvBatched(t *testing.T) {
+
+	sparqlTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(wikidataResultsJSONExampleDotCom))
+	}))
+	defer func() { sparqlTestServer.Close() }()
+
+	// apiTestServer describes the latest revision of each title it is
+	// asked for.
+	var mutex sync.Mutex
+	var requests int
+	apiTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		fmt.Fprint(res, latestRevisionsJSON(req.URL.Query().Get("titles")))
+	}))
+	defer func() { apiTestServer.Close() }()
+
+	wikiprov.SetWikibaseAPIURL(apiTestServer.URL)
+
+	opts := Options{Threads: 5}
+	opts.History = 1
+
+	provs, err := SPARQLWithProvOptions(context.Background(), sparqlTestServer.URL, "testQuery", "uri", opts)
+	if err != nil {
+		t.Errorf("Unexpected error '%s' from SPARQLWithProvOptions", err)
+	}
+	if len(provs.Provenance) != 6 {
+		t.Errorf("Expected results length '%d', but got '%d'", 6, len(provs.Provenance))
+	}
+	// Provenance is returned in the order entities first appear in the
+	// results.
+	order := []string{"Q100135637", "Q100136218", "Q100136955", "Q100136960", "Q100137240", "Q100151671"}
+	for idx, prov := range provs.Provenance {
+		if prov.Revision != 2600 || idx >= len(order) || prov.Title != order[idx] {
+			t.Errorf("Batched provenance is incorrect at '%d': '%+v'", idx, prov)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected provenance in a single batched request, received: '%d' requests", requests)
+	}
+}
+
+// countingTransport counts the requests made through it.
+type countingTransport struct {
+	mutex    sync.Mutex
+	requests map[string]int
+}
+
+// RoundTrip implements http.RoundTripper for countingTransport.
+func (transport *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport.mutex.Lock()
+	transport.requests[req.URL.Host]++
+	transport.mutex.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// TestSPARQLWithProvClient ensures that the client given in the options
+// is used to retrieve provenance and that its transport is shared with
+// the SPARQL query.
+func TestSPARQLWithProvClient(t *testing.T) {
+
+	sparqlTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(wikidataResultsJSONExampleDotCom))
+	}))
+	defer func() { sparqlTestServer.Close() }()
+
+	apiTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(attachedProvenance))
+	}))
+	defer func() { apiTestServer.Close() }()
+
+	// The default client should not be used.
+	wikiprov.SetWikibaseAPIURL("http://127.0.0.1:0")
+
+	transport := &countingTransport{requests: make(map[string]int)}
+	opts := Options{Threads: 5}
+	opts.History = 2
+	opts.Client = wikiprov.NewClient(apiTestServer.URL)
+	opts.Client.APIURL = apiTestServer.URL
+	opts.Client.HTTPClient = &http.Client{Transport: transport}
+	opts.Rate = 1000
+	opts.Burst = 2
+
+	provs, err := SPARQLWithProvOptions(context.Background(), sparqlTestServer.URL, "testQuery", "uri", opts)
+	if err != nil {
+		t.Errorf("Unexpected error '%s' from SPARQLWithProvOptions", err)
+	}
+	if len(provs.Provenance) != 6 {
+		t.Errorf("Expected results length '%d', but got '%d'", 6, len(provs.Provenance))
+	}
+	sparqlHost := strings.TrimPrefix(sparqlTestServer.URL, "http://")
+	apiHost := strings.TrimPrefix(apiTestServer.URL, "http://")
+	if transport.requests[sparqlHost] != 1 || transport.requests[apiHost] != 6 {
+		t.Errorf("Requests not made through the client's transport: '%v'", transport.requests)
+	}
+	// The rate limiter is only used for this call.
+	if opts.Client.RateLimiter != nil {
+		t.Errorf("Client given in options should not be modified by the rate limit")
+	}
+}
+
+// TestAdaptiveConcurrency ensures that concurrency grows additively
+// with fast, successful responses and is halved when Wikibase asks us
+// to slow down.
+func TestAdaptiveConcurrency(t *testing.T) {
+	var debug bytes.Buffer
+	adaptive := newAdaptiveConcurrency(4, &debug)
+	respond := func(latency time.Duration, success bool) {
+		if err := adaptive.acquire(context.Background()); err != nil {
+			t.Fatalf("Unexpected error acquiring worker: %s", err)
+		}
+		adaptive.release(latency, success)
+	}
+	fast := func(rounds int) {
+		for idx := 0; idx < rounds; idx++ {
+			respond(time.Millisecond, true)
+		}
+	}
+	// 1 + 2 + 3 fast responses take us from one to four workers, which
+	// is the maximum.
+	fast(1 + 2 + 3 + 10)
+	if adaptive.limit != 4 {
+		t.Errorf("Expected concurrency to grow to '%d', received: '%d'", 4, adaptive.limit)
+	}
+	// Slow responses and failures don't increase concurrency.
+	adaptive.limit = 2
+	respond(fastResponse, true)
+	respond(time.Millisecond, false)
+	if adaptive.limit != 2 {
+		t.Errorf("Expected concurrency to stay at '%d', received: '%d'", 2, adaptive.limit)
+	}
+	adaptive.limit = 4
+	adaptive.throttled(&wikiprov.RateLimitError{Err: errors.New("slow down")})
+	adaptive.throttled(&wikiprov.RateLimitError{Err: errors.New("slow down")})
+	if adaptive.limit != 2 {
+		t.Errorf("Expected concurrency to halve once during cooldown to '%d', received: '%d'", 2, adaptive.limit)
+	}
+	last := adaptive.timeline[len(adaptive.timeline)-1]
+	if last.Concurrency != 2 || !strings.Contains(last.Reason, wikiprov.ClassRateLimited) {
+		t.Errorf("Decrease not recorded in timeline: '%s'", last)
+	}
+	if lines := strings.Count(debug.String(), "concurrency: "); lines != len(adaptive.timeline) {
+		t.Errorf("Expected '%d' lines of debug output, received: '%d'", len(adaptive.timeline), lines)
+	}
+
+	// Workers waiting for their turn exit when the context is done.
+	for worker := 0; worker < adaptive.limit; worker++ {
+		if err := adaptive.acquire(context.Background()); err != nil {
+			t.Fatalf("Unexpected error acquiring worker: %s", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := adaptive.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context error waiting for worker, received: %v", err)
+	}
+}
+
+// TestSPARQLWithProvAdaptive ensures that provenance is retrieved with
+// adaptive concurrency and that throttling is reported in the debug
+// output.
+func TestSPARQLWithProvAdaptive(t *testing.T) {
+
+	sparqlTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(wikidataResultsJSONExampleDotCom))
+	}))
+	defer func() { sparqlTestServer.Close() }()
+
+	// apiTestServer throttles the fourth request.
+	var mutex sync.Mutex
+	var requests int
+	apiTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		requests++
+		count := requests
+		mutex.Unlock()
+		if count == 4 {
+			res.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		res.WriteHeader(200)
+		res.Write([]byte(attachedProvenance))
+	}))
+	defer func() { apiTestServer.Close() }()
+
+	var debug bytes.Buffer
+	opts := Options{Threads: 4, Adaptive: true, Debug: &debug}
+	opts.History = 2
+	opts.Client = wikiprov.NewClient(apiTestServer.URL)
+	opts.Client.APIURL = apiTestServer.URL
+	opts.Client.Retry = wikiprov.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}
+
+	provs, err := SPARQLWithProvOptions(context.Background(), sparqlTestServer.URL, "testQuery", "uri", opts)
+	if err != nil {
+		t.Errorf("Unexpected error '%s' from SPARQLWithProvOptions", err)
+	}
+	if len(provs.Provenance) != 6 {
+		t.Errorf("Expected results length '%d', but got '%d'", 6, len(provs.Provenance))
+	}
+	for _, expected := range []string{"start", "increase", "decrease after: rate_limited"} {
+		if !strings.Contains(debug.String(), expected) {
+			t.Errorf("Expected '%s' in debug output, received: '%s'", expected, debug.String())
+		}
+	}
+	if opts.Client.OnThrottle != nil {
+		t.Errorf("Client given in options should not be modified by adaptive concurrency")
+	}
+}
+
+// TestGetProvThreadedOrder ensures that provenance is returned in the
+// order the QIDs were given however long each request takes, and that
+// progress is reported as it is retrieved.
+func TestGetProvThreadedOrder(t *testing.T) {
+
+	// apiTestServer answers requests for lower QIDs more slowly and
+	// fails to answer for every fifth QID.
+	apiTestServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		titles := req.URL.Query().Get("titles")
+		number, _ := strconv.Atoi(strings.TrimPrefix(titles, "item:Q"))
+		time.Sleep(time.Duration(20-number) * time.Millisecond)
+		if number%5 == 0 {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(res, latestRevisionsJSON(titles))
+	}))
+	defer func() { apiTestServer.Close() }()
+
+	var qids []string
+	for idx := 1; idx <= 20; idx++ {
+		qids = append(qids, fmt.Sprintf("Q%d", idx))
+	}
+
+	client := wikiprov.NewClient(apiTestServer.URL)
+	client.APIURL = apiTestServer.URL
+	var reports []Progress
+	pool := provenancePool{
+		client:   client,
+		opts:     wikiprov.Options{History: 2},
+		threads:  8,
+		progress: func(progress Progress) { reports = append(reports, progress) },
+	}
+	provs, err := getProvThreaded(context.Background(), qids, pool)
+	if err != nil {
+		t.Errorf("Unexpected error from getProvThreaded: %s", err)
+	}
+	if len(provs) != len(qids) {
+		t.Fatalf("Expected '%d' results, received: '%d'", len(qids), len(provs))
+	}
+	for idx, prov := range provs {
+		if prov.Title != qids[idx] {
+			t.Errorf("Provenance out of order at '%d', received: '%s', expected: '%s'", idx, prov.Title, qids[idx])
+		}
+	}
+	// Progress is reported once before any provenance is retrieved and
+	// then for each QID.
+	if len(reports) != len(qids)+1 {
+		t.Fatalf("Expected progress to be reported '%d' times, received: '%d'", len(qids)+1, len(reports))
+	}
+	for idx, progress := range reports {
+		if progress.Done != idx || progress.Total != len(qids) {
+			t.Errorf("Progress incorrect, received: '%+v'", progress)
+		}
+	}
+	if last := reports[len(reports)-1]; last.Errors != 4 {
+		t.Errorf("Expected '%d' errors to be reported, received: '%d'", 4, last.Errors)
+	}
+}
@@ -1,5 +1,32 @@
 package spargo
 
+import (
+	"time"
+
+	"github.com/ross-spencer/wikiprov/pkg/wikiprov"
+)
+
+// testRevisions describes the revisions we expect to be returned from
+// the threadedProvenance and attachedProvenance test strings below.
+var testRevisions = []wikiprov.Revision{
+	{
+		RevisionID: 2600,
+		ParentID:   1247208427,
+		User:       "Emmanuel Goldstein",
+		Timestamp:  time.Date(2020, time.August, 31, 23, 13, 0, 0, time.UTC),
+		SHA1:       "4fa4f3344e2db600c11273028e63ba21976ede80",
+		Comment:    "edit comment #1",
+	},
+	{
+		RevisionID: 1000,
+		ParentID:   1120067133,
+		User:       "Robert Smith",
+		Timestamp:  time.Date(2020, time.August, 1, 23, 13, 0, 0, time.UTC),
+		SHA1:       "88a134dc3b112584e143003cadf0fdf3a4503dfe",
+		Comment:    "edit comment #2",
+	},
+}
+
 // errorTest and errorTests provide a simple test layout for when there
 // is no connection to the server at any point in time.
 //
@@ -0,0 +1,193 @@
+package wikiprov
+
+// Batched requests for the latest revision of up to 50 entities at a
+// time.
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// MaxBatchSize is the most entities whose latest revision will be
+// requested from Wikibase at once.
+const MaxBatchSize = 50
+
+// wdBatch describes the latest revisions of a batch of pages. Titles
+// are normalized by the API, e.g. item:Q12345 becomes Q12345 on
+// Wikidata, so we need to know how to find each title we asked for.
+type wdBatch struct {
+	Query struct {
+		Normalized []struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+		} `json:"normalized"`
+		Pages page `json:"pages"`
+	} `json:"query"`
+}
+
+// Batchable tells the caller whether provenance requested with the
+// options can be retrieved in batches, i.e. only the latest revision
+// of each entity is needed. Provenance as of a point in time cannot be
+// batched as the API only accepts a start time for a single page, and
+// snapshots need a request for each entity.
+func (opts Options) Batchable() bool {
+	return opts.History == 1 &&
+		!opts.filtered() &&
+		!opts.Creation &&
+		!opts.Snapshot &&
+		opts.AsOf.IsZero()
+}
+
+// GetProvenanceBatch requests provenance for each of the given entities
+// from the default client configured with the given options.
+func GetProvenanceBatch(ctx context.Context, ids []string, opts Options) []Provenance {
+	return defaultClient.GetProvenanceBatch(ctx, ids, opts)
+}
+
+// GetProvenanceBatch requests provenance for each of the given entities
+// from the client's Wikibase API, returning it in the same order as the
+// entities were given. Where an entity's provenance can't be retrieved
+// its Title is the ID requested and Error describes why.
+//
+// When the options are Batchable the latest revision of up to
+// MaxBatchSize entities is requested at once. Entities that need more
+// than a batch can tell us, e.g. redirects and missing entities, and
+// provenance requested with other options are retrieved one at a time
+// with GetProvenance.
+//
+// When the client has a Cache, provenance that is still current is
+// returned from it. See Client.Cache.
+func (client *Client) GetProvenanceBatch(ctx context.Context, ids []string, opts Options) []Provenance {
+	if client.Cache != nil {
+		return client.cachedBatch(ctx, ids, opts)
+	}
+	provs, _ := client.fetchBatch(ctx, ids, opts)
+	return provs
+}
+
+// fetchBatch requests provenance for each of the given entities from
+// Wikibase, without consulting the cache. The latest revision of each
+// entity, i.e. its lastrevid, is returned alongside its provenance
+// where it is known.
+func (client *Client) fetchBatch(ctx context.Context, ids []string, opts Options) ([]Provenance, []int) {
+	provs := make([]Provenance, len(ids))
+	lastRevIDs := make([]int, len(ids))
+	for start := 0; start < len(ids); start += MaxBatchSize {
+		end := start + MaxBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var batched map[string]latest
+		var err error
+		if opts.Batchable() && end-start > 1 {
+			batched, err = client.latestBatch(ctx, ids[start:end], opts)
+		}
+		for idx := start; idx < end; idx++ {
+			id := ids[idx]
+			if err != nil {
+				provs[idx] = Provenance{Title: id, Error: fmt.Errorf(
+					"retrieving provenance from Wikibase endpoint for: %s: %w (history len: '%d')",
+					id,
+					err,
+					opts.History,
+				)}
+				continue
+			}
+			if found, ok := batched[id]; ok {
+				provs[idx], lastRevIDs[idx] = found.prov, found.lastRevID
+				continue
+			}
+			prov, lastRevID, provErr := client.getProvenance(ctx, id, opts)
+			if provErr != nil {
+				prov.Title = id
+				prov.Error = provErr
+			}
+			provs[idx], lastRevIDs[idx] = prov, lastRevID
+		}
+	}
+	return provs, lastRevIDs
+}
+
+// latest is the provenance of an entity retrieved in a batch along with
+// its latest revision.
+type latest struct {
+	prov      Provenance
+	lastRevID int
+}
+
+// latestBatch requests the latest revision of each of the given
+// entities in a single request. Entities whose provenance the batch
+// can't fully describe, e.g. redirects, are left out of the results
+// so that they can be requested individually.
+func (client *Client) latestBatch(ctx context.Context, ids []string, opts Options) (map[string]latest, error) {
+	props, err := client.revisionProperties(opts)
+	if err != nil {
+		return nil, err
+	}
+	titles := batchTitles(ids)
+	query := url.Values{}
+	query.Set("format", format)
+	query.Set("action", action)
+	query.Set("titles", strings.Join(titles, "|"))
+	query.Set("prop", prop)
+	query.Set("rvprop", props)
+
+	var batch wdBatch
+	if err := client.newPaginator(query).next(ctx, &batch); err != nil {
+		return nil, err
+	}
+
+	provs := make(map[string]latest)
+	for idx, id := range ids {
+		page, ok := batch.lookup(id, titles[idx])
+		if !ok || len(page.Revisions) == 0 {
+			continue
+		}
+		if page.Missing || page.Invalid || page.Redirect {
+			continue
+		}
+		results := wdRevisions{Query: pages{Pages: map[string]revisions{page.Title: page}}}
+		prov := results.normalize(client)
+		if client.RenderHistory {
+			prov.History = prov.HistoryStrings()
+		}
+		provs[id] = latest{prov: prov, lastRevID: page.LastRevID}
+	}
+	return provs, nil
+}
+
+// batchTitles returns the titles to request for the given entities.
+func batchTitles(ids []string) []string {
+	const itemPrefix = "item:"
+	titles := make([]string, len(ids))
+	for idx, id := range ids {
+		titles[idx] = fmt.Sprintf("%s%s", itemPrefix, id)
+	}
+	return titles
+}
+
+// lookup returns the page in the batch for the entity requested with
+// the given title.
+func (batch wdBatch) lookup(id string, title string) (revisions, bool) {
+	for _, norm := range batch.Query.Normalized {
+		if norm.From == title {
+			title = norm.To
+			break
+		}
+	}
+	for _, page := range batch.Query.Pages {
+		if page.Title == title {
+			return page, true
+		}
+	}
+	// Wikibases with items in the main namespace may not tell us the
+	// prefixed title has been normalized.
+	for _, page := range batch.Query.Pages {
+		if page.Title == id {
+			return page, true
+		}
+	}
+	return revisions{}, false
+}
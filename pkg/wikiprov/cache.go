@@ -0,0 +1,364 @@
+package wikiprov
+
+// Caching provenance between requests, checked against each entity's
+// latest revision ID.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CacheEntry is the provenance of an entity held in a Cache along with
+// the entity's latest revision when it was stored.
+type CacheEntry struct {
+	LastRevID  int        `json:"lastrevid"`
+	Provenance Provenance `json:"provenance"`
+}
+
+// Cache stores provenance between requests. Keys describe the Wikibase
+// instance, the entity, and the options provenance was requested with.
+// Implementations must be safe to use concurrently.
+type Cache interface {
+	// Get returns the entry stored for the key and whether one was
+	// found.
+	Get(key string) (CacheEntry, bool)
+	// Put stores the entry for the key, replacing any already stored.
+	Put(key string, entry CacheEntry) error
+}
+
+// FileCache is a Cache storing each entry as a JSON file under a
+// directory.
+type FileCache struct {
+	dir string
+}
+
+// NewFileCache returns a FileCache storing entries under dir, creating
+// the directory if it doesn't exist.
+func NewFileCache(dir string) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create cache directory: %w", err)
+	}
+	return &FileCache{dir: dir}, nil
+}
+
+// Dir returns the directory the cache is stored under.
+func (cache *FileCache) Dir() string {
+	return cache.dir
+}
+
+// fileCacheEntry is the file an entry is stored in. The key is stored
+// alongside the entry so that we know the file describes it.
+type fileCacheEntry struct {
+	Key string `json:"key"`
+	CacheEntry
+}
+
+// path returns the file the key is stored in. Files are spread across
+// sub-directories so that none grows too large.
+func (cache *FileCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(cache.dir, name[:2], fmt.Sprintf("%s.json", name))
+}
+
+// Get returns the entry stored for the key. Entries that cannot be read
+// are treated as missing so that they are retrieved again.
+func (cache *FileCache) Get(key string) (CacheEntry, bool) {
+	data, err := ioutil.ReadFile(cache.path(key))
+	if err != nil {
+		return CacheEntry{}, false
+	}
+	var entry fileCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Key != key {
+		return CacheEntry{}, false
+	}
+	return entry.CacheEntry, true
+}
+
+// Put stores the entry for the key. The entry is written to a temporary
+// file first so that a reader never sees it half written.
+func (cache *FileCache) Put(key string, entry CacheEntry) error {
+	data, err := json.Marshal(fileCacheEntry{Key: key, CacheEntry: entry})
+	if err != nil {
+		return err
+	}
+	path := cache.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// cacheKey returns the key provenance for the entity is cached under.
+// Anything that changes the provenance returned is part of the key,
+// i.e. every option it was requested with and the bases its permalink
+// and entity URI are built from, so that clients of the same Wikibase
+// configured differently don't share provenance.
+func (client *Client) cacheKey(id string, props string, opts Options) string {
+	key, _ := json.Marshal(struct {
+		API        string
+		Permalink  string
+		EntityURI  string
+		Entity     string
+		MaxHistory int
+		Revision   string
+		Options    Options
+	}{
+		client.APIURL,
+		client.PermalinkBase,
+		client.EntityURI,
+		id,
+		client.MaxHistory,
+		props,
+		opts,
+	})
+	return string(key)
+}
+
+// expiring is implemented by caches whose entries expire, e.g. a
+// MemoryCache with a time to live. Their entries are treated as current
+// until they expire rather than checked against Wikibase.
+type expiring interface {
+	expires() bool
+}
+
+// trusted tells the caller whether entries in the client's cache can be
+// returned without checking they are still current.
+func (client *Client) trusted() bool {
+	cache, ok := client.Cache.(expiring)
+	return ok && cache.expires()
+}
+
+// cached returns provenance from a cache entry, rendering its history
+// as the client asks.
+func (client *Client) cached(entry CacheEntry) Provenance {
+	prov := entry.Provenance
+	if client.RenderHistory {
+		prov.History = prov.HistoryStrings()
+	}
+	return prov
+}
+
+// current tells the caller whether a cache entry is current given the
+// entity's latest revision.
+func (entry CacheEntry) current(lastRevID int) bool {
+	return lastRevID != 0 && entry.LastRevID == lastRevID
+}
+
+// store adds provenance to the cache. Redirects aren't stored because
+// the entity they point to can change without the redirect changing,
+// and nor are missing entities. A cache that cannot be written to
+// doesn't stop provenance being returned, it is just requested again
+// next time.
+func (client *Client) store(key string, lastRevID int, prov Provenance) {
+	if lastRevID == 0 || prov.Title == "" || prov.Missing {
+		return
+	}
+	if prov.RedirectedTo != "" || prov.RedirectedFrom != "" {
+		return
+	}
+	// History is rendered when provenance is returned from the cache
+	// as the client asks.
+	prov.History = nil
+	_ = client.Cache.Put(key, CacheEntry{LastRevID: lastRevID, Provenance: prov})
+}
+
+// cachedProvenance returns provenance for an entity from the cache if
+// it is still current, otherwise it is requested from Wikibase and
+// stored. Where the same provenance is already being requested the
+// result of that request is shared, including the check of whether the
+// cache is current.
+func (client *Client) cachedProvenance(ctx context.Context, id string, opts Options) (Provenance, error) {
+	props, err := client.revisionProperties(opts)
+	if err != nil {
+		return Provenance{}, err
+	}
+	key := client.cacheKey(id, props, opts)
+	current, leader := flights.claim(key)
+	if !leader {
+		if prov, err, ok := client.shared(ctx, current); ok {
+			return prov, err
+		}
+		prov, _, err := client.getProvenance(ctx, id, opts)
+		return prov, err
+	}
+	prov, err := client.lead(ctx, id, key, opts)
+	flights.land(key, current, prov, err)
+	return prov, err
+}
+
+// lead returns provenance for an entity on behalf of a flight, from
+// the cache if it is still current, otherwise from Wikibase.
+func (client *Client) lead(ctx context.Context, id string, key string, opts Options) (Provenance, error) {
+	if entry, ok := client.Cache.Get(key); ok {
+		if client.trusted() {
+			return client.cached(entry), nil
+		}
+		lastRevIDs, err := client.lastRevisions(ctx, []string{id})
+		if err != nil {
+			return Provenance{}, fmt.Errorf(
+				"checking cached provenance against Wikibase endpoint for: %s: %w",
+				id,
+				err,
+			)
+		}
+		if entry.current(lastRevIDs[id]) {
+			return client.cached(entry), nil
+		}
+	}
+	prov, lastRevID, err := client.getProvenance(ctx, id, opts)
+	if err == nil {
+		client.store(key, lastRevID, prov)
+	}
+	return prov, err
+}
+
+// cachedBatch returns provenance for each of the given entities from
+// the cache where it is still current and requests the rest from
+// Wikibase, storing it. Provenance already being requested is shared
+// rather than requested again.
+func (client *Client) cachedBatch(ctx context.Context, ids []string, opts Options) []Provenance {
+	props, err := client.revisionProperties(opts)
+	if err != nil {
+		provs, _ := client.fetchBatch(ctx, ids, opts)
+		return provs
+	}
+	provs := make([]Provenance, len(ids))
+	type claimed struct {
+		idx     int
+		id      string
+		key     string
+		current *flight
+		entry   CacheEntry
+	}
+	var leading, following, stale []claimed
+	for idx, id := range ids {
+		key := client.cacheKey(id, props, opts)
+		current, leader := flights.claim(key)
+		if !leader {
+			following = append(following, claimed{idx: idx, id: id, key: key, current: current})
+			continue
+		}
+		lead := claimed{idx: idx, id: id, key: key, current: current}
+		entry, ok := client.Cache.Get(key)
+		switch {
+		case ok && client.trusted():
+			provs[idx] = client.cached(entry)
+			flights.land(key, current, provs[idx], nil)
+		case ok:
+			lead.entry = entry
+			stale = append(stale, lead)
+		default:
+			leading = append(leading, lead)
+		}
+	}
+	// Entries that may be out of date are checked against the latest
+	// revision of each entity together.
+	if len(stale) > 0 {
+		staleIDs := make([]string, len(stale))
+		for staleIdx, lead := range stale {
+			staleIDs[staleIdx] = lead.id
+		}
+		lastRevIDs, err := client.lastRevisions(ctx, staleIDs)
+		for _, lead := range stale {
+			if err != nil {
+				err := fmt.Errorf(
+					"checking cached provenance against Wikibase endpoint for: %s: %w",
+					lead.id,
+					err,
+				)
+				provs[lead.idx] = Provenance{Title: lead.id, Error: err}
+				flights.land(lead.key, lead.current, provs[lead.idx], err)
+				continue
+			}
+			if lead.entry.current(lastRevIDs[lead.id]) {
+				provs[lead.idx] = client.cached(lead.entry)
+				flights.land(lead.key, lead.current, provs[lead.idx], nil)
+				continue
+			}
+			leading = append(leading, lead)
+		}
+	}
+	// Flights we lead are landed before we wait for any led by others
+	// so that two batches cannot wait on each other.
+	if len(leading) > 0 {
+		missed := make([]string, len(leading))
+		for leadIdx, lead := range leading {
+			missed[leadIdx] = lead.id
+		}
+		// The latest revision of each entity is returned alongside its
+		// provenance so it needn't be requested separately.
+		fetched, lastRevIDs := client.fetchBatch(ctx, missed, opts)
+		for leadIdx, prov := range fetched {
+			lead := leading[leadIdx]
+			provs[lead.idx] = prov
+			if prov.Error == nil {
+				client.store(lead.key, lastRevIDs[leadIdx], prov)
+			}
+			flights.land(lead.key, lead.current, prov, prov.Error)
+		}
+	}
+	for _, follow := range following {
+		prov, err, ok := client.shared(ctx, follow.current)
+		if !ok {
+			prov, _, err = client.getProvenance(ctx, follow.id, opts)
+		}
+		if err != nil {
+			prov.Title = follow.id
+			prov.Error = err
+		}
+		provs[follow.idx] = prov
+	}
+	return provs
+}
+
+// lastRevisions requests the latest revision ID of each of the given
+// entities, MaxBatchSize entities at a time. Entities that don't exist
+// are left out of the results.
+func (client *Client) lastRevisions(ctx context.Context, ids []string) (map[string]int, error) {
+	lastRevIDs := make(map[string]int)
+	for start := 0; start < len(ids); start += MaxBatchSize {
+		end := start + MaxBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		titles := batchTitles(ids[start:end])
+		query := url.Values{}
+		query.Set("format", format)
+		query.Set("action", action)
+		query.Set("titles", strings.Join(titles, "|"))
+		query.Set("prop", "info")
+		var batch wdBatch
+		if err := client.newPaginator(query).next(ctx, &batch); err != nil {
+			return lastRevIDs, err
+		}
+		for idx, id := range ids[start:end] {
+			page, ok := batch.lookup(id, titles[idx])
+			if !ok || bool(page.Missing) || bool(page.Invalid) || page.LastRevID == 0 {
+				continue
+			}
+			lastRevIDs[id] = page.LastRevID
+		}
+	}
+	return lastRevIDs, nil
+}
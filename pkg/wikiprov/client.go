@@ -0,0 +1,102 @@
+package wikiprov
+
+// Client and its configuration. A client describes a single Wikibase
+// instance so that callers can talk to more than one Wikibase in the
+// same process without the configuration of one interfering with
+// another.
+
+import (
+	"net/http"
+)
+
+// Client provides the configuration needed to retrieve provenance from
+// a single Wikibase instance. Clients are safe to use concurrently
+// once configured.
+type Client struct {
+	// HTTPClient is used to make requests to the Wikibase API. If it
+	// is nil the http.Client shared by clients created with NewClient
+	// is used.
+	HTTPClient *http.Client
+	// APIURL is the Wikibase API endpoint, e.g.
+	// https://www.wikidata.org/w/api.php
+	APIURL string
+	// PermalinkBase is the index page used to build permalinks, e.g.
+	// https://www.wikidata.org/w/index.php
+	PermalinkBase string
+	// EntityURI is the concept URI prefix for entities, e.g.
+	// http://wikidata.org/entity/
+	EntityURI string
+	// Agent is the user-agent sent with each request.
+	Agent string
+	// MaxHistory caps the number of revisions returned when
+	// FullHistory is requested. Zero means no cap.
+	MaxHistory int
+	// RenderHistory adds the revisions of an entity to its provenance
+	// as pre-formatted strings as well as Revision records.
+	RenderHistory bool
+	// Retry configures how failed requests are retried.
+	Retry RetryPolicy
+	// MaxLag is sent to Wikibase as the maxlag parameter, asking it to
+	// refuse requests while its database replication lag is greater
+	// than the given number of seconds. Refused requests are retried.
+	// Zero means the parameter is not sent.
+	MaxLag int
+	// RevisionProperties are the properties of each revision requested
+	// from Wikibase, e.g. ids, user, tags. See KnownRevisionProperties.
+	// The default set is requested when it is empty.
+	RevisionProperties []string
+	// RateLimiter limits the rate of requests made by the client,
+	// including retries. It is shared by every go routine using the
+	// client. Requests are not limited when it is nil.
+	RateLimiter *RateLimiter
+	// OnThrottle is called each time Wikibase asks the client to slow
+	// down, e.g. with a 429 or 503 status or a maxlag error, before the
+	// request is retried. It must be safe to call concurrently.
+	OnThrottle func(err error)
+	// Cache, if set, stores provenance between requests. Before cached
+	// provenance is returned the entity's latest revision is requested
+	// from Wikibase, in batches of up to MaxBatchSize entities, and the
+	// provenance is only returned if the entity hasn't been edited
+	// since it was stored. Entries held by a MemoryCache with a time to
+	// live are returned without checking until they expire.
+	Cache Cache
+}
+
+// defaultClient is used by the package level functions that pre-date
+// Client, e.g. GetWikidataProvenance.
+var defaultClient *Client
+
+// NewClient returns a Client configured for the Wikibase found at
+// baseURL, e.g. https://www.wikidata.org/
+func NewClient(baseURL string) *Client {
+	client := &Client{
+		HTTPClient: sharedHTTPClient,
+		EntityURI:  wdEntity,
+		Agent:      agent,
+		Retry:      DefaultRetryPolicy,
+	}
+	client.SetWikibaseURLs(baseURL)
+	return client
+}
+
+// DefaultClient returns the client used by the package level
+// functions.
+func DefaultClient() *Client {
+	return defaultClient
+}
+
+// SetWikibaseURLs sets the API and permalink URLs for the client from
+// the base URL of a Wikibase instance.
+func (client *Client) SetWikibaseURLs(baseURL string) {
+	client.APIURL = constructWikibaseAPIURL(baseURL)
+	client.PermalinkBase = constructWikibaseIndexURL(baseURL)
+}
+
+// httpClient returns the http.Client to make requests with, providing
+// the shared client if the caller hasn't given one.
+func (client *Client) httpClient() *http.Client {
+	if client.HTTPClient == nil {
+		return sharedHTTPClient
+	}
+	return client.HTTPClient
+}
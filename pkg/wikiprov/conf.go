@@ -19,19 +19,39 @@ const defaultBaseURI = "https://www.wikidata.org/"
 const indexPage = "w/index.php"
 const apiPage = "w/api.php"
 
-var wikibaseAPI = ""
-var wikibasePermalinkBase = ""
-
 var format = "json"
 var action = "query"
-var prop = "revisions"
+var prop = "revisions|info"
+
+// paramLimit is the number of revisions to return in a request.
+const paramLimit = "rvlimit"
+
+// paramDirection is the order revisions are returned in. Revisions are
+// returned newest first unless it is set to directionNewer.
+const paramDirection = "rvdir"
+const directionNewer = "newer"
+const directionOlder = "older"
+
+// paramStart is the timestamp from which revisions are listed.
+const paramStart = "rvstart"
+
+// maxRevisionsPerRequest is the most revisions that Wikibase will
+// return in a single request for most users. More are retrieved by
+// following the API's continuation.
+const maxRevisionsPerRequest = 500
+
+// maxErrorBody is the most of a response body kept to describe an
+// unexpected status.
+const maxErrorBody = 512
 
 var revisionPropertiesDefault = [...]string{"ids", "user", "comment", "timestamp", "sha1"}
 
 func init() {
 	agent = getVersionFromBuildFlags()
-	wikibaseAPI = constructWikibaseAPIURL(defaultBaseURI)
-	wikibasePermalinkBase = constructWikibaseIndexURL(defaultBaseURI)
+	// The default configuration doesn't read any files so it can't
+	// fail to create a client.
+	sharedHTTPClient, _ = DefaultTransportConfig.NewHTTPClient()
+	defaultClient = NewClient(defaultBaseURI)
 }
 
 // getVersionFromBuildFlags returns a version string from the build
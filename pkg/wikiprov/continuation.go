@@ -0,0 +1,84 @@
+package wikiprov
+
+// Following MediaWiki API continuation to page through results that
+// don't fit in a single response.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+)
+
+// apiContinue is the continuation block of a MediaWiki API response.
+type apiContinue struct {
+	Continue map[string]interface{} `json:"continue"`
+}
+
+// values returns the continuation values to add to the next request.
+func (cont apiContinue) values() map[string]string {
+	values := map[string]string{}
+	for key, value := range cont.Continue {
+		values[key] = fmt.Sprintf("%v", value)
+	}
+	return values
+}
+
+// paginator follows the continuation of a MediaWiki API query until
+// there are no more results to return. The query can be modified
+// between pages, e.g. to reduce the number of results requested.
+type paginator struct {
+	client   *Client
+	query    url.Values
+	cont     map[string]string
+	finished bool
+}
+
+// newPaginator returns a paginator for the given query against the
+// client's Wikibase API.
+func (client *Client) newPaginator(query url.Values) *paginator {
+	return &paginator{
+		client: client,
+		query:  query,
+	}
+}
+
+// more tells the caller whether there are more pages to retrieve.
+func (pages *paginator) more() bool {
+	return !pages.finished
+}
+
+// next retrieves the next page of results and decodes it into result.
+func (pages *paginator) next(ctx context.Context, result interface{}) error {
+	query := url.Values{}
+	for key, value := range pages.query {
+		query[key] = value
+	}
+	for key, value := range pages.cont {
+		query.Set(key, value)
+	}
+	request, err := pages.client.newRequest(ctx, query)
+	if err != nil {
+		return err
+	}
+	data, err := pages.client.doRequest(ctx, request)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return &DecodeError{Err: err}
+	}
+	var cont apiContinue
+	if err := json.Unmarshal(data, &cont); err != nil {
+		return &DecodeError{Err: err}
+	}
+	next := cont.values()
+	// A continuation that doesn't move us on would have us requesting
+	// the same page forever.
+	if len(next) == 0 || reflect.DeepEqual(next, pages.cont) {
+		pages.finished = true
+	}
+	pages.cont = next
+	return nil
+}
@@ -0,0 +1,192 @@
+package wikiprov
+
+// Verifying the fixity of revisions using the SHA1 Wikibase reports
+// for their content.
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxContentRevisionsPerRequest is the most revisions whose content
+// Wikibase will return in a single request.
+const maxContentRevisionsPerRequest = 50
+
+// mainSlot is the slot entities are stored in. Entities are stored in a
+// single slot, so the SHA1 of a revision is that of its main slot.
+const mainSlot = "main"
+
+// Reasons a revision cannot be verified.
+const (
+	ReasonMismatch = "content does not match sha1"
+	ReasonChanged  = "sha1 differs from that recorded"
+	ReasonMissing  = "revision not found"
+	ReasonHidden   = "content or sha1 hidden"
+)
+
+// Verification describes the fixity check of a single revision.
+// Recorded is the SHA1 recorded in stored provenance, if any, Reported
+// is the SHA1 Wikibase reports now, and Computed is the SHA1 of the
+// revision's content. Reason describes why a revision isn't Verified.
+type Verification struct {
+	Title    string `json:"Title"`
+	Revision int    `json:"Revision"`
+	Recorded string `json:"Recorded,omitempty"`
+	Reported string `json:"Reported,omitempty"`
+	Computed string `json:"Computed,omitempty"`
+	Verified bool   `json:"Verified"`
+	Reason   string `json:"Reason,omitempty"`
+}
+
+// wdContent describes the content of revisions.
+type wdContent struct {
+	Query struct {
+		Pages map[string]struct {
+			Title     string `json:"title"`
+			Revisions []struct {
+				RevisionID int     `json:"revid"`
+				SHA1       string  `json:"sha1"`
+				SHA1Hidden apiFlag `json:"sha1hidden"`
+				TextHidden apiFlag `json:"texthidden"`
+				Slots      map[string]struct {
+					Content    *string `json:"*"`
+					TextHidden apiFlag `json:"texthidden"`
+				} `json:"slots"`
+			} `json:"revisions"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+// VerifyProvenance verifies the revisions described by stored
+// provenance using the default client. See Client.VerifyProvenance.
+func VerifyProvenance(ctx context.Context, prov Provenance) ([]Verification, error) {
+	return defaultClient.VerifyProvenance(ctx, prov)
+}
+
+// VerifyRevision verifies a single revision of an entity using the
+// default client. See Client.VerifyRevision.
+func VerifyRevision(ctx context.Context, id string, revision int) (Verification, error) {
+	return defaultClient.VerifyRevision(ctx, id, revision)
+}
+
+// VerifyProvenance verifies each revision described by stored
+// provenance against the client's Wikibase: the SHA1 of its content
+// must match that reported by Wikibase and that recorded in the
+// provenance, if one was. Where the provenance has no revisions its
+// latest Revision is verified. A FixityError listing the revisions
+// that cannot be verified is returned alongside the verifications if
+// any fail.
+func (client *Client) VerifyProvenance(ctx context.Context, prov Provenance) ([]Verification, error) {
+	revs := prov.Revisions
+	if len(revs) == 0 && prov.Revision != 0 {
+		revs = []Revision{{RevisionID: prov.Revision}}
+	}
+	return client.verify(ctx, prov.Title, revs)
+}
+
+// VerifyRevision verifies the content of a single revision of an
+// entity against the SHA1 reported by the client's Wikibase.
+func (client *Client) VerifyRevision(ctx context.Context, id string, revision int) (Verification, error) {
+	verifications, err := client.verify(ctx, id, []Revision{{RevisionID: revision}})
+	if len(verifications) == 0 {
+		return Verification{Title: id, Revision: revision}, err
+	}
+	return verifications[0], err
+}
+
+// verify verifies the given revisions, requesting the content of up to
+// maxContentRevisionsPerRequest at a time.
+func (client *Client) verify(ctx context.Context, id string, revs []Revision) ([]Verification, error) {
+	verifications := make([]Verification, len(revs))
+	byRevision := make(map[int]*Verification)
+	for idx, rev := range revs {
+		verifications[idx] = Verification{
+			Title:    id,
+			Revision: rev.RevisionID,
+			Recorded: rev.SHA1,
+			Reason:   ReasonMissing,
+		}
+		byRevision[rev.RevisionID] = &verifications[idx]
+	}
+	for start := 0; start < len(revs); start += maxContentRevisionsPerRequest {
+		end := start + maxContentRevisionsPerRequest
+		if end > len(revs) {
+			end = len(revs)
+		}
+		ids := make([]string, 0, end-start)
+		for _, rev := range revs[start:end] {
+			ids = append(ids, strconv.Itoa(rev.RevisionID))
+		}
+		query := url.Values{}
+		query.Set("format", format)
+		query.Set("action", action)
+		query.Set("prop", "revisions")
+		query.Set("revids", strings.Join(ids, "|"))
+		query.Set("rvprop", "ids|sha1|content")
+		query.Set("rvslots", mainSlot)
+		// Content can be too large to return in one response, in which
+		// case the API continues with the revisions it left out.
+		pages := client.newPaginator(query)
+		for pages.more() {
+			var content wdContent
+			if err := pages.next(ctx, &content); err != nil {
+				return verifications, fmt.Errorf(
+					"retrieving content from Wikibase endpoint for: %s: %w",
+					id,
+					err,
+				)
+			}
+			content.check(byRevision)
+		}
+	}
+	var failed []int
+	for _, verification := range verifications {
+		if !verification.Verified {
+			failed = append(failed, verification.Revision)
+		}
+	}
+	if len(failed) > 0 {
+		return verifications, &FixityError{Title: id, Revisions: failed}
+	}
+	return verifications, nil
+}
+
+// check verifies the revisions in the response, updating the
+// verification of each.
+func (content wdContent) check(byRevision map[int]*Verification) {
+	for _, page := range content.Query.Pages {
+		for _, rev := range page.Revisions {
+			verification, ok := byRevision[rev.RevisionID]
+			if !ok {
+				continue
+			}
+			slot := rev.Slots[mainSlot]
+			if rev.SHA1Hidden || rev.TextHidden || slot.TextHidden {
+				verification.Reason = ReasonHidden
+				continue
+			}
+			if slot.Content == nil {
+				// The content wasn't returned in this response, e.g.
+				// because it is too large, and will be continued.
+				continue
+			}
+			sum := sha1.Sum([]byte(*slot.Content))
+			verification.Reported = rev.SHA1
+			verification.Computed = hex.EncodeToString(sum[:])
+			switch {
+			case !strings.EqualFold(verification.Computed, verification.Reported):
+				verification.Reason = ReasonMismatch
+			case verification.Recorded != "" && !strings.EqualFold(verification.Recorded, verification.Reported):
+				verification.Reason = ReasonChanged
+			default:
+				verification.Verified = true
+				verification.Reason = ""
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package wikiprov
+
+// Sharing a single request between callers asking for the same
+// provenance at the same time.
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// flight is a request for provenance in progress. done is closed once
+// prov and err are set.
+type flight struct {
+	done chan struct{}
+	prov Provenance
+	err  error
+}
+
+// flightGroup tracks the requests for provenance in progress by their
+// cache key.
+type flightGroup struct {
+	mutex   sync.Mutex
+	flights map[string]*flight
+}
+
+// flights is shared by every client so that requests are shared across
+// clients configured for the same Wikibase, e.g. copies of a client
+// made by spargo.
+var flights = &flightGroup{flights: make(map[string]*flight)}
+
+// claim returns the flight in progress for the key. If there isn't one
+// a new flight is started and the caller leads it, i.e. must request
+// the provenance and land the flight.
+func (group *flightGroup) claim(key string) (*flight, bool) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if current, ok := group.flights[key]; ok {
+		return current, false
+	}
+	current := &flight{done: make(chan struct{})}
+	group.flights[key] = current
+	return current, true
+}
+
+// land records the result of the flight, releasing those waiting for
+// it. History is rendered by each waiting client as it asks so it isn't
+// shared.
+func (group *flightGroup) land(key string, current *flight, prov Provenance, err error) {
+	prov.History = nil
+	prov.Error = nil
+	current.prov, current.err = prov, err
+	group.mutex.Lock()
+	delete(group.flights, key)
+	group.mutex.Unlock()
+	close(current.done)
+}
+
+// shared waits for the result of a flight led by another caller. False
+// is returned if the leader's request was cancelled, in which case the
+// caller should make the request itself.
+func (client *Client) shared(ctx context.Context, current *flight) (Provenance, error, bool) {
+	select {
+	case <-current.done:
+	case <-ctx.Done():
+		return Provenance{}, ctx.Err(), true
+	}
+	if isContextError(current.err) && ctx.Err() == nil {
+		return Provenance{}, nil, false
+	}
+	prov := current.prov
+	if client.RenderHistory {
+		prov.History = prov.HistoryStrings()
+	}
+	return prov, current.err, true
+}
+
+// isContextError tells the caller whether the error is the result of a
+// context being cancelled or its deadline passing.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
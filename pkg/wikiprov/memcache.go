@@ -0,0 +1,113 @@
+package wikiprov
+
+// A bounded in-memory cache for long-running processes whose entries
+// expire after a time to live.
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// MemoryCache is a Cache holding a bounded number of entries in memory.
+// A MemoryCache is safe to use concurrently.
+type MemoryCache struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	recent  *list.List
+}
+
+// memoryCacheEntry is an entry held in a MemoryCache along with the
+// time it was stored.
+type memoryCacheEntry struct {
+	key    string
+	entry  CacheEntry
+	stored time.Time
+}
+
+// NewMemoryCache returns a MemoryCache holding up to size entries, each
+// of which expires ttl after it was stored. A size of zero or less
+// doesn't bound the cache and a ttl of zero or less means entries don't
+// expire, in which case they are checked against Wikibase like those of
+// any other cache.
+func NewMemoryCache(size int, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		recent:  list.New(),
+	}
+}
+
+// Len returns the number of entries held.
+func (cache *MemoryCache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.recent.Len()
+}
+
+// expires tells the caller whether entries expire, i.e. the cache has a
+// time to live.
+func (cache *MemoryCache) expires() bool {
+	return cache.ttl > 0
+}
+
+// Get returns the entry stored for the key unless it has expired.
+func (cache *MemoryCache) Get(key string) (CacheEntry, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	elem, ok := cache.entries[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	held := elem.Value.(*memoryCacheEntry)
+	if cache.ttl > 0 && time.Since(held.stored) >= cache.ttl {
+		cache.remove(elem)
+		return CacheEntry{}, false
+	}
+	cache.recent.MoveToFront(elem)
+	return copyCacheEntry(held.entry), true
+}
+
+// Put stores the entry for the key, evicting the least recently used
+// entries if the cache is full.
+func (cache *MemoryCache) Put(key string, entry CacheEntry) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	held := &memoryCacheEntry{
+		key:    key,
+		entry:  copyCacheEntry(entry),
+		stored: time.Now(),
+	}
+	if elem, ok := cache.entries[key]; ok {
+		elem.Value = held
+		cache.recent.MoveToFront(elem)
+		return nil
+	}
+	cache.entries[key] = cache.recent.PushFront(held)
+	for cache.size > 0 && cache.recent.Len() > cache.size {
+		cache.remove(cache.recent.Back())
+	}
+	return nil
+}
+
+// remove removes an entry from the cache. The caller must hold the
+// mutex.
+func (cache *MemoryCache) remove(elem *list.Element) {
+	cache.recent.Remove(elem)
+	delete(cache.entries, elem.Value.(*memoryCacheEntry).key)
+}
+
+// copyCacheEntry copies the entry's revisions so that provenance held
+// in the cache isn't modified by callers modifying what they were
+// given.
+func copyCacheEntry(entry CacheEntry) CacheEntry {
+	if entry.Provenance.Revisions != nil {
+		revs := make([]Revision, len(entry.Provenance.Revisions))
+		copy(revs, entry.Provenance.Revisions)
+		entry.Provenance.Revisions = revs
+	}
+	return entry
+}
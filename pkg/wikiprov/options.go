@@ -0,0 +1,170 @@
+package wikiprov
+
+// Options that can be configured for each request for provenance.
+
+import (
+	"net"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Options configure a single request for provenance.
+type Options struct {
+	// History is the number of revisions to return. FullHistory
+	// returns every revision up to the client's MaxHistory.
+	History int
+	// Properties limits the revisions returned to those whose edit
+	// summaries reference one of the given property IDs, e.g. P2748.
+	// Further history is requested from Wikibase until History
+	// matching revisions are found or the history is exhausted.
+	Properties []string
+	// FollowRedirects returns the provenance of the entity a redirected
+	// entity points to, e.g. the item another was merged into, rather
+	// than that of the redirect itself.
+	FollowRedirects bool
+	// Creation adds the date an entity was created and the user that
+	// created it to its provenance. Unless the entity's first revision
+	// has already been retrieved this requires an additional request.
+	Creation bool
+	// RevisionProperties overrides the client's revision properties for
+	// this request.
+	RevisionProperties []string
+	// AsOf, if set, returns provenance as it was at the given time,
+	// i.e. the revision in force at that time and the history leading
+	// up to it.
+	AsOf time.Time
+	// Since and Until limit the revisions returned to those made at or
+	// after Since and at or before Until. Either can be left unset.
+	Since time.Time
+	Until time.Time
+	// Users limits the revisions returned to those made by the given
+	// users. ExcludeUsers leaves out those made by the given users.
+	Users        []string
+	ExcludeUsers []string
+	// ExcludeBots leaves out revisions made by users in the bot group.
+	// The groups of the users who made the revisions are requested from
+	// Wikibase to find out who they are.
+	ExcludeBots bool
+	// ExcludeAnonymous leaves out revisions made by anonymous, i.e. IP
+	// address, editors.
+	ExcludeAnonymous bool
+	// Snapshot attaches the JSON of the entity at the revision the
+	// provenance describes. This requires an additional request.
+	Snapshot bool
+}
+
+// asOf returns the time provenance is requested as of formatted for
+// the API, or an empty string if it isn't set.
+func (opts Options) asOf() string {
+	if opts.AsOf.IsZero() {
+		return ""
+	}
+	return opts.AsOf.UTC().Format(time.RFC3339)
+}
+
+// start returns the time revisions are listed backwards from,
+// formatted for the API, or an empty string if they are listed from the
+// latest revision. Provenance as of a point in time is listed from that
+// time so that the revision in force is found. Otherwise revisions made
+// after Until are never returned so they aren't requested.
+func (opts Options) start() string {
+	if !opts.AsOf.IsZero() {
+		return opts.asOf()
+	}
+	if !opts.Until.IsZero() {
+		return opts.Until.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+// filtered tells the caller whether the revisions returned are being
+// filtered.
+func (opts Options) filtered() bool {
+	return len(opts.Properties) > 0 ||
+		!opts.Since.IsZero() ||
+		!opts.Until.IsZero() ||
+		opts.filtersUsers()
+}
+
+// filtersUsers tells the caller whether the revisions returned are
+// being filtered by the users that made them.
+func (opts Options) filtersUsers() bool {
+	return len(opts.Users) > 0 ||
+		len(opts.ExcludeUsers) > 0 ||
+		opts.ExcludeBots ||
+		opts.ExcludeAnonymous
+}
+
+// matches tells the caller whether a revision should be returned
+// given the options requested. Bots are excluded separately as they
+// need to be looked up. See Client.matcher. Revisions made after Until
+// aren't usually requested, but they are still checked for in case
+// they were, e.g. when provenance is requested as of a later time.
+func (opts Options) matches(rev Revision) bool {
+	if len(opts.Properties) > 0 && !rev.Summary().References(opts.Properties...) {
+		return false
+	}
+	if !opts.Since.IsZero() && rev.Timestamp.Before(opts.Since) {
+		return false
+	}
+	if !opts.Until.IsZero() && rev.Timestamp.After(opts.Until) {
+		return false
+	}
+	if len(opts.Users) > 0 && !containsUser(opts.Users, rev.User) {
+		return false
+	}
+	if containsUser(opts.ExcludeUsers, rev.User) {
+		return false
+	}
+	if opts.ExcludeAnonymous && anonymous(rev) {
+		return false
+	}
+	return true
+}
+
+// filterRevisions returns the revisions that match up to the given
+// limit. Zero means no limit.
+func filterRevisions(revs []Revision, limit int, match func(Revision) bool) []Revision {
+	var filtered []Revision
+	for _, rev := range revs {
+		if limit > 0 && len(filtered) >= limit {
+			break
+		}
+		if match(rev) {
+			filtered = append(filtered, rev)
+		}
+	}
+	return filtered
+}
+
+// anonymous tells the caller whether the revision was made by an
+// anonymous editor. The API flags these revisions, but we check the
+// user is an IP address as well in case the flag wasn't requested.
+func anonymous(rev Revision) bool {
+	return rev.Anonymous || net.ParseIP(rev.User) != nil
+}
+
+// containsUser tells the caller whether the user is one of the users
+// given.
+func containsUser(users []string, user string) bool {
+	for _, candidate := range users {
+		if canonicalUser(candidate) == canonicalUser(user) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalUser returns a user name as MediaWiki stores it, i.e. with
+// spaces rather than underscores and its first letter in upper case,
+// so that names given in either form can be compared.
+func canonicalUser(user string) string {
+	user = strings.TrimSpace(strings.Replace(user, "_", " ", -1))
+	first, size := utf8.DecodeRuneInString(user)
+	if first == utf8.RuneError {
+		return user
+	}
+	return string(unicode.ToUpper(first)) + user[size:]
+}
@@ -0,0 +1,90 @@
+package wikiprov
+
+// Revision properties requested from Wikibase, i.e. rvprop.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// knownRevisionProperties are the revision properties that can be
+// requested. Each populates a field of Revision.
+var knownRevisionProperties = []string{
+	"ids",
+	"flags",
+	"timestamp",
+	"user",
+	"userid",
+	"size",
+	"sha1",
+	"contentmodel",
+	"comment",
+	"tags",
+}
+
+// requiredRevisionProperties are always requested as provenance can't
+// be described without them.
+var requiredRevisionProperties = []string{"ids", "timestamp"}
+
+// KnownRevisionProperties returns the revision properties that can be
+// requested from Wikibase.
+func KnownRevisionProperties() []string {
+	return append([]string{}, knownRevisionProperties...)
+}
+
+// validRevisionProperty tells the caller whether a revision property
+// can be requested.
+func validRevisionProperty(prop string) bool {
+	for _, known := range knownRevisionProperties {
+		if prop == known {
+			return true
+		}
+	}
+	return false
+}
+
+// joinRevisionProperties validates the revision properties requested
+// and returns them as the value of rvprop. Required properties are
+// added if they are missing. The default properties are returned when
+// none are requested.
+func joinRevisionProperties(props []string) (string, error) {
+	if len(props) == 0 {
+		return getRevisionProperties(), nil
+	}
+	var joined []string
+	seen := make(map[string]bool)
+	for _, prop := range append(append([]string{}, requiredRevisionProperties...), props...) {
+		prop = strings.ToLower(strings.TrimSpace(prop))
+		if prop == "" || seen[prop] {
+			continue
+		}
+		if !validRevisionProperty(prop) {
+			return "", fmt.Errorf("%w: '%s'", ErrRevisionProperty, prop)
+		}
+		seen[prop] = true
+		joined = append(joined, prop)
+	}
+	return strings.Join(joined, "|"), nil
+}
+
+// revisionProperties returns the value of rvprop for a request made
+// with the given options. Properties requested in the options take
+// precedence over those configured for the client. Properties needed to
+// filter revisions as the options ask are added to them.
+func (client *Client) revisionProperties(opts Options) (string, error) {
+	props := opts.RevisionProperties
+	if len(props) == 0 {
+		props = client.RevisionProperties
+	}
+	if len(props) == 0 {
+		return joinRevisionProperties(props)
+	}
+	props = append([]string{}, props...)
+	if len(opts.Properties) > 0 {
+		props = append(props, "comment")
+	}
+	if opts.filtersUsers() {
+		props = append(props, "user")
+	}
+	return joinRevisionProperties(props)
+}
@@ -0,0 +1,92 @@
+package wikiprov
+
+// Limiting the rate of requests made to Wikibase with a token bucket
+// shared by every request a client makes.
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the rate of requests using a token bucket. The
+// bucket holds up to burst tokens and is refilled at rate tokens per
+// second. Each request takes a token, waiting for one if the bucket is
+// empty. A RateLimiter is safe to use concurrently.
+type RateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing rate requests per
+// second on average with bursts of up to burst requests. A rate of
+// zero or less doesn't limit requests. A burst less than one is
+// treated as one.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Rate returns the average number of requests per second allowed.
+func (limiter *RateLimiter) Rate() float64 {
+	return limiter.rate
+}
+
+// Burst returns the number of requests that can be made at once.
+func (limiter *RateLimiter) Burst() int {
+	return int(limiter.burst)
+}
+
+// Wait blocks until a request can be made or the context is done, in
+// which case the context's error is returned. A nil RateLimiter never
+// blocks.
+func (limiter *RateLimiter) Wait(ctx context.Context) error {
+	if limiter == nil || limiter.rate <= 0 {
+		return ctx.Err()
+	}
+	wait := limiter.reserve()
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	if err := sleepContext(ctx, wait); err != nil {
+		limiter.cancel()
+		return err
+	}
+	return nil
+}
+
+// reserve takes a token from the bucket, returning how long the caller
+// must wait before it can be used. The bucket can go into debt so that
+// callers waiting at the same time are spaced out.
+func (limiter *RateLimiter) reserve() time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := time.Now()
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.burst {
+		limiter.tokens = limiter.burst
+	}
+	limiter.last = now
+	limiter.tokens--
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+}
+
+// cancel returns a reserved token that wasn't used.
+func (limiter *RateLimiter) cancel() {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.tokens++
+}
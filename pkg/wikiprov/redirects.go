@@ -0,0 +1,98 @@
+package wikiprov
+
+// Redirected and missing entities, i.e. items merged into another and
+// entities that have been deleted.
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// itemNamespace is the namespace of items on Wikibase instances that
+// don't use the main namespace for them.
+const itemNamespace = "Item:"
+
+// wdRedirects describes the redirects resolved by the API, e.g.
+//
+//	"redirects": [
+//		{
+//			"from": "Q1234",
+//			"to": "Q5678"
+//		}
+//	]
+type wdRedirects struct {
+	Query struct {
+		Redirects []struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+		} `json:"redirects"`
+	} `json:"query"`
+}
+
+// wdLogEvents describes the log events returned by the API.
+type wdLogEvents struct {
+	Query struct {
+		LogEvents []struct {
+			Action string `json:"action"`
+		} `json:"logevents"`
+	} `json:"query"`
+}
+
+// redirect sets the entity that a redirected entity points to, and if
+// the caller has asked, returns the provenance of that entity instead.
+func (client *Client) redirect(ctx context.Context, prov Provenance, opts Options) (Provenance, error) {
+	query := url.Values{}
+	query.Set("format", format)
+	query.Set("action", action)
+	query.Set("titles", prov.Title)
+	query.Set("redirects", "1")
+	var redirects wdRedirects
+	if err := client.newPaginator(query).next(ctx, &redirects); err != nil {
+		return prov, err
+	}
+	for _, redirect := range redirects.Query.Redirects {
+		if redirect.From == prov.Title {
+			prov.RedirectedTo = strings.TrimPrefix(redirect.To, itemNamespace)
+		}
+	}
+	if !opts.FollowRedirects || prov.RedirectedTo == "" {
+		return prov, nil
+	}
+	// Redirects are only followed once so that we can't be sent round
+	// in circles.
+	opts.FollowRedirects = false
+	target, err := client.GetProvenance(ctx, prov.RedirectedTo, opts)
+	target.RedirectedFrom = prov.Title
+	return target, err
+}
+
+// notFound returns provenance describing an entity that is missing
+// and an error telling the caller it wasn't found. Wikibase's deletion
+// log is checked to see if the entity has been deleted.
+func (client *Client) notFound(ctx context.Context, id string, page revisions) (Provenance, error) {
+	prov := Provenance{Title: page.Title, Missing: bool(page.Missing)}
+	if prov.Title == "" {
+		prov.Title = id
+	}
+	if page.Missing {
+		query := url.Values{}
+		query.Set("format", format)
+		query.Set("action", action)
+		query.Set("list", "logevents")
+		query.Set("letype", "delete")
+		query.Set("letitle", prov.Title)
+		query.Set("lelimit", "1")
+		var events wdLogEvents
+		// If we can't tell whether the entity was deleted it is still
+		// missing, so the error isn't returned to the caller.
+		if err := client.newPaginator(query).next(ctx, &events); err == nil {
+			for _, event := range events.Query.LogEvents {
+				if event.Action == "delete" {
+					prov.Deleted = true
+				}
+			}
+		}
+	}
+	return prov, &NotFoundError{Title: id, Deleted: prov.Deleted}
+}
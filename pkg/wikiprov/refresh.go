@@ -0,0 +1,118 @@
+package wikiprov
+
+// Refreshing stored provenance with only the revisions made since it
+// was retrieved.
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// paramEndID is the revision at which the API stops listing
+// revisions.
+const paramEndID = "rvendid"
+
+// RefreshProvenance brings stored provenance up to date using the
+// default client. See Client.RefreshProvenance.
+func RefreshProvenance(ctx context.Context, stored Provenance, opts Options) (Provenance, int, error) {
+	return defaultClient.RefreshProvenance(ctx, stored, opts)
+}
+
+// RefreshProvenance brings stored provenance up to date, requesting
+// only the revisions of the entity newer than the stored Revision and
+// adding them to the stored history. The number of revisions added is
+// returned alongside the refreshed provenance.
+//
+// Revisions are filtered by the options as they are for GetProvenance.
+// When History is greater than zero it caps the number of revisions
+// kept, otherwise the whole history is kept. Where the stored
+// provenance has no Revision its history is requested with
+// GetProvenance. Provenance cannot be refreshed as of a point in time.
+func (client *Client) RefreshProvenance(ctx context.Context, stored Provenance, opts Options) (Provenance, int, error) {
+	id := stored.Title
+	if id == "" {
+		return stored, 0, fmt.Errorf("cannot refresh provenance without a title")
+	}
+	if !opts.AsOf.IsZero() {
+		return stored, 0, fmt.Errorf("cannot refresh provenance as of a point in time")
+	}
+	if stored.Revision == 0 {
+		prov, err := client.GetProvenance(ctx, id, opts)
+		return prov, len(prov.Revisions), err
+	}
+
+	props, err := client.revisionProperties(opts)
+	if err != nil {
+		return stored, 0, err
+	}
+	query := buildQuery(id, maxRevisionsPerRequest, props)
+	query.Set(paramEndID, strconv.Itoa(stored.Revision))
+	pages := client.newPaginator(query)
+
+	var history wdRevisions
+	for pages.more() {
+		var page wdRevisions
+		if err := pages.next(ctx, &page); err != nil {
+			return stored, 0, fmt.Errorf(
+				"refreshing provenance from Wikibase endpoint for: %s: %w (revision: '%d')",
+				id,
+				err,
+				stored.Revision,
+			)
+		}
+		if history.merge(page) == 0 {
+			break
+		}
+	}
+
+	_, page := history.page()
+	if page.Missing || page.Invalid {
+		prov, err := client.notFound(ctx, id, page)
+		return prov, 0, err
+	}
+
+	if opts.ExcludeBots {
+		if err := client.lookupBots(ctx, page.Revisions); err != nil {
+			return stored, 0, err
+		}
+	}
+	match := client.matcher(opts)
+	latest := history.normalize(client)
+	var added []Revision
+	for _, rev := range latest.Revisions {
+		// The stored revision is listed too as rvendid is inclusive.
+		if rev.RevisionID > stored.Revision && match(rev) {
+			added = append(added, rev)
+		}
+	}
+
+	prov := stored
+	if latest.Revision > stored.Revision {
+		// The entity has been edited even if none of the edits match
+		// the options, so we record its latest revision so that they
+		// aren't requested again.
+		prov.Revision = latest.Revision
+		prov.Modified = latest.Modified
+		prov.Permalink = latest.Permalink
+	}
+	revs := make([]Revision, 0, len(added)+len(stored.Revisions))
+	revs = append(revs, added...)
+	revs = append(revs, stored.Revisions...)
+	if opts.History > 0 && len(revs) > opts.History {
+		revs = revs[:opts.History]
+	}
+	prov.Revisions = revs
+	prov.History = nil
+	if client.RenderHistory {
+		prov.History = prov.HistoryStrings()
+	}
+
+	if page.Redirect {
+		// The stored history describes the entity itself so the
+		// redirect is flagged rather than followed.
+		redirected, err := client.redirect(ctx, prov, Options{})
+		return redirected, len(added), err
+	}
+	return prov, len(added), nil
+}
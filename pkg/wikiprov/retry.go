@@ -0,0 +1,146 @@
+package wikiprov
+
+// Retrying requests that Wikibase throttled, e.g. with a 429, 503, or
+// maxlag error, or that failed.
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy configures how requests to Wikibase are retried when they
+// fail for reasons that might be temporary, e.g. throttling.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is made,
+	// including the first. Values less than one are treated as one.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It is doubled for
+	// each retry after.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts where the server hasn't
+	// told us how long to wait.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is the policy given to new clients.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   1 * time.Second,
+	MaxDelay:    60 * time.Second,
+}
+
+// paramMaxLag asks Wikibase to refuse the request if its database
+// replication lag is greater than the given number of seconds.
+const paramMaxLag = "maxlag"
+
+// temporaryError describes a failed request that can be retried.
+// RetryAfter is set when the server has told us how long to wait.
+type temporaryError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+// Error implements the error interface for temporaryError.
+func (temp temporaryError) Error() string {
+	return temp.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (temp temporaryError) Unwrap() error {
+	return temp.err
+}
+
+// attempts returns the number of times a request should be attempted.
+func (policy RetryPolicy) attempts() int {
+	if policy.MaxAttempts < 1 {
+		return 1
+	}
+	return policy.MaxAttempts
+}
+
+// delay returns how long to wait before the next attempt. The server's
+// Retry-After value is honored if it has been given, otherwise we back
+// off exponentially with jitter so that workers don't retry in
+// lockstep.
+func (policy RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	backoff := policy.BaseDelay
+	for idx := 1; idx < attempt && (policy.MaxDelay <= 0 || backoff < policy.MaxDelay); idx++ {
+		backoff *= 2
+	}
+	if policy.MaxDelay > 0 && backoff > policy.MaxDelay {
+		backoff = policy.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	half := int64(backoff / 2)
+	return time.Duration(half + randInt63n(half+1))
+}
+
+// random provides jitter for retries.
+var random = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// randInt63n returns a random number in [0,n) safely across go
+// routines.
+func randInt63n(n int64) int64 {
+	random.Lock()
+	defer random.Unlock()
+	return random.Int63n(n)
+}
+
+// parseRetryAfter reads the Retry-After header which can be given
+// either in seconds or as an HTTP date.
+func parseRetryAfter(header http.Header) time.Duration {
+	const retryHeader = "Retry-After"
+	value := strings.TrimSpace(header.Get(retryHeader))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// retryableStatus tells the caller whether a request that returned the
+// given status code might succeed if it is tried again.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// throttling tells the caller whether an error means Wikibase wants us
+// to slow down rather than that it has failed.
+func throttling(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusServiceUnavailable {
+		return true
+	}
+	return errors.Is(err, ErrRateLimited)
+}
@@ -0,0 +1,67 @@
+package wikiprov
+
+// Snapshots of the JSON of an entity at a revision.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GetSnapshot requests the JSON of an entity at the given revision
+// from the default client.
+func GetSnapshot(ctx context.Context, id string, revision int) (json.RawMessage, error) {
+	return defaultClient.GetSnapshot(ctx, id, revision)
+}
+
+// GetSnapshot requests the JSON of an entity at the given revision
+// from the client's Wikibase. The entity can be given by its title,
+// e.g. Property:P31, or its ID. The JSON is returned as Wikibase sent
+// it.
+func (client *Client) GetSnapshot(ctx context.Context, id string, revision int) (json.RawMessage, error) {
+	request, err := client.snapshotRequest(ctx, id, revision)
+	if err != nil {
+		return nil, err
+	}
+	data, err := client.doRequest(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, &DecodeError{Err: fmt.Errorf("snapshot of '%s' is not JSON", id)}
+	}
+	return json.RawMessage(data), nil
+}
+
+// snapshotRequest creates a request for the JSON of an entity at a
+// revision through the client's index page, e.g.
+//
+//	https://www.wikidata.org/w/index.php?
+//	   title=Special:EntityData/Q12345.json
+//	   &revision=1419131078
+func (client *Client) snapshotRequest(ctx context.Context, id string, revision int) (*http.Request, error) {
+	const paramTitle = "title"
+	const paramRevision = "revision"
+	req, err := http.NewRequestWithContext(ctx, "GET", client.PermalinkBase, nil)
+	if err != nil {
+		return nil, err
+	}
+	query := req.URL.Query()
+	query.Set(paramTitle, fmt.Sprintf("Special:EntityData/%s.json", entityID(id)))
+	query.Set(paramRevision, strconv.Itoa(revision))
+	req.URL.RawQuery = query.Encode()
+	req.Header.Add("User-Agent", client.Agent)
+	return req, nil
+}
+
+// entityID returns the ID of an entity given its title, e.g. P31 for
+// Property:P31. Special:EntityData only accepts IDs.
+func entityID(title string) string {
+	if idx := strings.LastIndex(title, ":"); idx >= 0 {
+		return title[idx+1:]
+	}
+	return title
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // structs for wikiprov
@@ -50,24 +51,93 @@ import (
 const wdEntity = "http://wikidata.org/entity/"
 
 type revision struct {
-	RevisionID int    `json:"revid"`
-	ParentID   int    `json:"parentid"`
-	User       string `json:"user"`
-	Timestamp  string `json:"timestamp"`
-	SHA1       string `json:"sha1"`
-	Comment    string `json:"comment"`
+	RevisionID   int      `json:"revid"`
+	ParentID     int      `json:"parentid"`
+	User         string   `json:"user"`
+	Timestamp    string   `json:"timestamp"`
+	SHA1         string   `json:"sha1"`
+	Comment      string   `json:"comment"`
+	Size         int      `json:"size"`
+	Tags         []string `json:"tags"`
+	Minor        apiFlag  `json:"minor"`
+	UserID       int      `json:"userid"`
+	ContentModel string   `json:"contentmodel"`
+	Anon         apiFlag  `json:"anon"`
 }
 
-// String creates a simple rendition of the revision history until we
-// know what else we want to do with it.
-func (rev revision) String() string {
-	return fmt.Sprintf("%s (oldid: %d): '%s' edited: '%s'", rev.Timestamp, rev.RevisionID, rev.User, rev.Comment)
+// apiFlag describes a boolean in the MediaWiki API's JSON format
+// version 1 where the key is present, e.g. `"minor": ""`, when the
+// value is true and is omitted otherwise.
+type apiFlag bool
+
+// UnmarshalJSON implements json.Unmarshaler for apiFlag. Any value
+// other than an explicit false is considered true.
+func (flag *apiFlag) UnmarshalJSON(data []byte) error {
+	*flag = apiFlag(string(data) != "false")
+	return nil
+}
+
+// typed converts the revision as returned by the API into the
+// Revision returned to the caller.
+func (rev revision) typed() Revision {
+	timestamp, _ := time.Parse(time.RFC3339, rev.Timestamp)
+	return Revision{
+		RevisionID:   rev.RevisionID,
+		ParentID:     rev.ParentID,
+		User:         rev.User,
+		Timestamp:    timestamp,
+		SHA1:         rev.SHA1,
+		Comment:      rev.Comment,
+		Size:         rev.Size,
+		Tags:         rev.Tags,
+		Minor:        bool(rev.Minor),
+		UserID:       rev.UserID,
+		ContentModel: rev.ContentModel,
+		Anonymous:    bool(rev.Anon),
+	}
+}
+
+// Revision describes a single revision of a Wikibase entity. Size,
+// Tags, Minor, UserID, and ContentModel are only populated when they
+// are requested from the API, e.g. using the size, tags, flags, userid,
+// and contentmodel revision properties. Anonymous is set when the
+// revision was made by an IP address rather than a named user.
+type Revision struct {
+	RevisionID   int       `json:"RevisionID,omitempty"`
+	ParentID     int       `json:"ParentID,omitempty"`
+	User         string    `json:"User,omitempty"`
+	Timestamp    time.Time `json:"Timestamp"`
+	SHA1         string    `json:"SHA1,omitempty"`
+	Comment      string    `json:"Comment,omitempty"`
+	Size         int       `json:"Size,omitempty"`
+	Tags         []string  `json:"Tags,omitempty"`
+	Minor        bool      `json:"Minor,omitempty"`
+	UserID       int       `json:"UserID,omitempty"`
+	ContentModel string    `json:"ContentModel,omitempty"`
+	Anonymous    bool      `json:"Anonymous,omitempty"`
+}
+
+// String creates a simple rendition of the revision. This was once the
+// only representation of history returned to the caller and it is kept
+// for compatibility.
+func (rev Revision) String() string {
+	return fmt.Sprintf(
+		"%s (oldid: %d): '%s' edited: '%s'",
+		rev.Timestamp.Format(time.RFC3339),
+		rev.RevisionID,
+		rev.User,
+		rev.Comment,
+	)
 }
 
 type revisions struct {
-	PageID    int    `json:"pageid"`
-	NS        int    `json:"ns"`
-	Title     string `json:"title"`
+	PageID    int     `json:"pageid"`
+	NS        int     `json:"ns"`
+	Title     string  `json:"title"`
+	Missing   apiFlag `json:"missing"`
+	Invalid   apiFlag `json:"invalid"`
+	Redirect  apiFlag `json:"redirect"`
+	LastRevID int     `json:"lastrevid"`
 	Revisions []revision
 }
 
@@ -77,10 +147,92 @@ type pages struct {
 	Pages page `json:"pages"`
 }
 
+// apiErrorResponse describes an error returned by the MediaWiki API,
+// e.g.
+//
+//	{
+//		"error": {
+//			"code": "maxlag",
+//			"info": "Waiting for 10.64.48.35: 0.64 seconds lagged.",
+//			"lag": 0.64
+//		}
+//	}
+type apiErrorResponse struct {
+	Error struct {
+		Code string  `json:"code"`
+		Info string  `json:"info"`
+		Lag  float64 `json:"lag"`
+	} `json:"error"`
+}
+
 type wdRevisions struct {
 	Query pages `json:"query"`
 }
 
+// page returns the page of revisions in the results along with its
+// key. We request a single page at a time.
+func (results *wdRevisions) page() (string, revisions) {
+	for key, page := range results.Query.Pages {
+		return key, page
+	}
+	return "", revisions{}
+}
+
+// length returns the number of revisions held.
+func (results *wdRevisions) length() int {
+	_, page := results.page()
+	return len(page.Revisions)
+}
+
+// merge adds the revisions from the next page of results to those we
+// already hold, returning the number of new revisions added.
+func (results *wdRevisions) merge(next wdRevisions) int {
+	if results.Query.Pages == nil {
+		*results = next
+		return results.length()
+	}
+	key, page := results.page()
+	_, nextPage := next.page()
+	seen := make(map[int]bool)
+	for _, rev := range page.Revisions {
+		seen[rev.RevisionID] = true
+	}
+	added := 0
+	for _, rev := range nextPage.Revisions {
+		if seen[rev.RevisionID] {
+			continue
+		}
+		page.Revisions = append(page.Revisions, rev)
+		added++
+	}
+	results.Query.Pages[key] = page
+	return added
+}
+
+// count returns the number of revisions held that match the given
+// function.
+func (results *wdRevisions) count(matches func(Revision) bool) int {
+	_, page := results.page()
+	count := 0
+	for _, rev := range page.Revisions {
+		if matches(rev.typed()) {
+			count++
+		}
+	}
+	return count
+}
+
+// reaches tells the caller whether the revisions held reach back before
+// the given time, i.e. the oldest was made before it. A zero time is
+// never reached.
+func (results *wdRevisions) reaches(since time.Time) bool {
+	_, page := results.page()
+	if since.IsZero() || len(page.Revisions) == 0 {
+		return false
+	}
+	return page.Revisions[len(page.Revisions)-1].typed().Timestamp.Before(since)
+}
+
 // normalize simplifies the wdInfo structure so it can be easily used by
 // the caller.
 //
@@ -126,19 +278,11 @@ type wdRevisions struct {
 //			}
 //		}
 //	}
-func (revisions *wdRevisions) normalize() Provenance {
+func (revisions *wdRevisions) normalize(client *Client) Provenance {
 
 	var prov Provenance
 
-	revMap := revisions.Query.Pages
-
-	var key string
-	for k := range revMap {
-		key = k
-		break
-	}
-
-	revs := revMap[key]
+	_, revs := revisions.page()
 	if len(revs.Revisions) < 1 {
 		return Provenance{}
 	}
@@ -148,38 +292,88 @@ func (revisions *wdRevisions) normalize() Provenance {
 	prov.Title = revs.Title
 
 	if prov.Title != "" {
-		prov.Entity = fmt.Sprintf("%s%s", wdEntity, prov.Title)
+		prov.Entity = fmt.Sprintf("%s%s", client.EntityURI, prov.Title)
 	}
 
 	prov.Revision = firstRecord.RevisionID
 	prov.Modified = firstRecord.Timestamp
-	prov.Permalink = prov.buildPermalink()
+	prov.Permalink = prov.buildPermalink(client.PermalinkBase)
 
 	for _, value := range revs.Revisions {
-		prov.History = append(prov.History, fmt.Sprintf("%s", value))
+		prov.Revisions = append(prov.Revisions, value.typed())
 	}
 
 	return prov
 }
 
 // Provenance provides simplified provenance information about a
-// Wikidata record.
+// Wikidata record. Revisions describes the history of the record, most
+// recent first. History is a pre-formatted rendering of the same
+// revisions which is only populated when the client is configured to
+// render it.
 type Provenance struct {
-	Title     string   `json:"Title,omitempty"`
-	Entity    string   `json:"Entity,omitempty"`
-	Revision  int      `json:"Revision,omitempty"`
-	Modified  string   `json:"Modified,omitempty"`
-	Permalink string   `json:"Permalink,omitempty"`
-	History   []string `json:"History,omitempty"`
-	Error     error    `json:"-"`
+	Title     string     `json:"Title,omitempty"`
+	Entity    string     `json:"Entity,omitempty"`
+	Revision  int        `json:"Revision,omitempty"`
+	Modified  string     `json:"Modified,omitempty"`
+	Permalink string     `json:"Permalink,omitempty"`
+	Revisions []Revision `json:"Revisions,omitempty"`
+	History   []string   `json:"History,omitempty"`
+	// RedirectedTo is the entity a redirected, e.g. merged, entity
+	// points to. RedirectedFrom is set instead when the redirect has
+	// been followed to describe the entity it points to.
+	RedirectedTo   string `json:"RedirectedTo,omitempty"`
+	RedirectedFrom string `json:"RedirectedFrom,omitempty"`
+	// Missing is set when the entity doesn't exist. Deleted is set
+	// when it is missing because it has been deleted.
+	Missing bool `json:"Missing,omitempty"`
+	Deleted bool `json:"Deleted,omitempty"`
+	// Created and Creator describe the first revision of the entity and
+	// are only populated when they are requested.
+	Created string `json:"Created,omitempty"`
+	Creator string `json:"Creator,omitempty"`
+	// AsOf is the time provenance was requested as of, if it was.
+	AsOf string `json:"AsOf,omitempty"`
+	// Snapshot is the JSON of the entity at Revision and is only
+	// populated when it is requested.
+	Snapshot json.RawMessage `json:"Snapshot,omitempty"`
+	Error    error           `json:"-"`
+}
+
+// MarshalJSON implements json.Marshaler for Provenance so that its
+// error, if any, is included in the output, e.g.
+//
+//	"Error": {
+//		"Class": "not_found",
+//		"Message": "entity not found in Wikibase: 'Q12345'"
+//	}
+func (prov Provenance) MarshalJSON() ([]byte, error) {
+	type provenance Provenance
+	return json.Marshal(struct {
+		provenance
+		Error *ErrorSummary `json:"Error,omitempty"`
+	}{
+		provenance(prov),
+		summarizeError(prov.Error),
+	})
+}
+
+// HistoryStrings renders the provenance's revisions as simple strings.
+func (prov Provenance) HistoryStrings() []string {
+	var history []string
+	for _, rev := range prov.Revisions {
+		history = append(history, rev.String())
+	}
+	return history
 }
 
 // buildPermalink creates a permalink based on the title and revision
-// values being set in the Provenance structure.
-func (prov *Provenance) buildPermalink() string {
+// values being set in the Provenance structure and the index page of
+// the Wikibase the record belongs to.
+func (prov *Provenance) buildPermalink(permalinkBase string) string {
 	const paramTitle = "title"
 	const paramOldID = "oldid"
-	req, _ := http.NewRequest("GET", wikibasePermalinkBase, nil)
+	req, _ := http.NewRequest("GET", permalinkBase, nil)
 	query := req.URL.Query()
 	title := prov.Title
 	oldid := prov.Revision
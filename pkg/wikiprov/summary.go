@@ -0,0 +1,184 @@
+package wikiprov
+
+// Parsing of Wikibase edit summaries into the action performed, its
+// arguments, and the value edited.
+
+import (
+	"regexp"
+	"strings"
+)
+
+// EditSummary describes the parts of a Wikibase edit summary.
+type EditSummary struct {
+	// Action is the Wikibase action, e.g. wbsetclaim-create.
+	Action string `json:"Action,omitempty"`
+	// Args are the arguments to the action, e.g. a language code.
+	Args []string `json:"Args,omitempty"`
+	// Properties are the IDs of the properties the summary refers to,
+	// e.g. P4152.
+	Properties []string `json:"Properties,omitempty"`
+	// Languages are the language codes of the terms edited.
+	Languages []string `json:"Languages,omitempty"`
+	// Value is the text describing the value that was edited.
+	Value string `json:"Value,omitempty"`
+	// Text is everything following the autocomment.
+	Text string `json:"Text,omitempty"`
+	// Tools are links to the tools used to make the edit.
+	Tools []ToolLink `json:"Tools,omitempty"`
+}
+
+// ToolLink describes a tool, e.g. QuickStatements, used to make an
+// edit and the batch the edit belonged to.
+type ToolLink struct {
+	Tool  string `json:"Tool"`
+	Batch string `json:"Batch,omitempty"`
+	Link  string `json:"Link,omitempty"`
+}
+
+var (
+	// autocommentRegex matches the autocomment at the start of a
+	// summary, e.g. /* wbsetlabel-add:1|ru */
+	autocommentRegex = regexp.MustCompile(`^\s*/\*\s*([^:*]+?)(?::(\d+)((?:\|[^*]*?)?))?\s*\*/\s*(.*)$`)
+	// propertyRegex matches links to properties, e.g. [[Property:P31]]
+	propertyRegex = regexp.MustCompile(`\[\[Property:(P\d+)(?:\|[^\]]*)?\]\]`)
+	// propertyValueRegex matches a leading property link describing
+	// the value that follows it.
+	propertyValueRegex = regexp.MustCompile(`^\[\[Property:P\d+(?:\|[^\]]*)?\]\]:\s*`)
+	// toolLinkRegex matches links to tools hosted on Toolforge, e.g.
+	// [[:toollabs:quickstatements/#/batch/151018|batch #151018]]
+	toolLinkRegex = regexp.MustCompile(`,?\s*\(?\[\[:toollabs:([^/|\]]+)/?([^|\]]*)(?:\|[^\]]*)?\]\]\)?`)
+	// quickStatementsRegex matches the hashtags QuickStatements adds
+	// to temporary batches, e.g.
+	// #quickstatements; #temporary_batch_1620750589351
+	quickStatementsRegex = regexp.MustCompile(`,?\s*#quickstatements;?\s*(?:#(temporary_batch_\d+))?`)
+	// batchRegex matches the batch ID in a QuickStatements link.
+	batchRegex = regexp.MustCompile(`batch/(\d+)`)
+	// editGroupsRegex matches the batch ID in an EditGroups link.
+	editGroupsRegex = regexp.MustCompile(`^b/([^/]+/[^/]+)`)
+)
+
+const quickStatements = "quickstatements"
+const editGroups = "editgroups"
+
+// termActions are the Wikibase actions whose first argument is the
+// language of the term being edited.
+var termActions = []string{
+	"wbsetlabel",
+	"wbsetdescription",
+	"wbsetaliases",
+	"wbsetlabeldescriptionaliases",
+}
+
+// languagesAction is the prefix of Wikibase actions whose last
+// argument is a list of the languages that were edited.
+const languagesAction = "wbeditentity-update-languages"
+
+// ParseEditSummary parses a revision comment into its parts. Comments
+// that don't begin with a Wikibase autocomment, e.g. those written by
+// hand, are returned as Text and Value only.
+func ParseEditSummary(comment string) EditSummary {
+	var summary EditSummary
+	summary.Text = strings.TrimSpace(comment)
+	if match := autocommentRegex.FindStringSubmatch(comment); match != nil {
+		summary.Action = strings.TrimSpace(match[1])
+		if match[3] != "" {
+			summary.Args = strings.Split(strings.TrimPrefix(match[3], "|"), "|")
+		}
+		summary.Text = strings.TrimSpace(match[4])
+	}
+	summary.Properties = parseProperties(comment)
+	summary.Languages = summary.parseLanguages()
+	summary.Tools = parseTools(summary.Text)
+	summary.Value = parseValue(summary.Text)
+	return summary
+}
+
+// parseProperties returns the IDs of the properties linked to in the
+// comment in the order they first appear.
+func parseProperties(comment string) []string {
+	var props []string
+	seen := make(map[string]bool)
+	for _, match := range propertyRegex.FindAllStringSubmatch(comment, -1) {
+		if seen[match[1]] {
+			continue
+		}
+		seen[match[1]] = true
+		props = append(props, match[1])
+	}
+	return props
+}
+
+// parseLanguages returns the languages edited according to the
+// summary's action and arguments.
+func (summary EditSummary) parseLanguages() []string {
+	if len(summary.Args) == 0 {
+		return nil
+	}
+	for _, action := range termActions {
+		if strings.HasPrefix(summary.Action, action) && summary.Args[0] != "" {
+			return []string{summary.Args[0]}
+		}
+	}
+	if !strings.HasPrefix(summary.Action, languagesAction) {
+		return nil
+	}
+	var langs []string
+	for _, lang := range strings.Split(summary.Args[len(summary.Args)-1], ",") {
+		lang = strings.TrimSpace(lang)
+		if lang != "" {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// parseTools returns the tools referenced in the text of a summary.
+func parseTools(text string) []ToolLink {
+	var tools []ToolLink
+	for _, match := range toolLinkRegex.FindAllStringSubmatch(text, -1) {
+		tool := ToolLink{Tool: match[1], Link: match[2]}
+		switch tool.Tool {
+		case quickStatements:
+			if batch := batchRegex.FindStringSubmatch(tool.Link); batch != nil {
+				tool.Batch = batch[1]
+			}
+		case editGroups:
+			if batch := editGroupsRegex.FindStringSubmatch(tool.Link); batch != nil {
+				tool.Batch = batch[1]
+			}
+		}
+		tools = append(tools, tool)
+	}
+	for _, match := range quickStatementsRegex.FindAllStringSubmatch(text, -1) {
+		tools = append(tools, ToolLink{Tool: quickStatements, Batch: match[1]})
+	}
+	return tools
+}
+
+// parseValue returns the value described by the text of a summary,
+// i.e. without the property it belongs to or links to tools.
+func parseValue(text string) string {
+	value := toolLinkRegex.ReplaceAllString(text, "")
+	value = quickStatementsRegex.ReplaceAllString(value, "")
+	value = propertyValueRegex.ReplaceAllString(value, "")
+	return strings.TrimSpace(value)
+}
+
+// References tells the caller whether the summary refers to any of the
+// given properties. Property IDs are compared case-insensitively, e.g.
+// p31 and P31 are equivalent.
+func (summary EditSummary) References(props ...string) bool {
+	for _, prop := range props {
+		for _, summaryProp := range summary.Properties {
+			if strings.EqualFold(prop, summaryProp) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Summary parses the revision's comment into its parts.
+func (rev Revision) Summary() EditSummary {
+	return ParseEditSummary(rev.Comment)
+}
@@ -0,0 +1,136 @@
+package wikiprov
+
+// A shared HTTP transport. Creating a new http.Client and transport for
+// each request means connections to Wikibase are never reused and each
+// request is made without timeouts. Clients created with NewClient
+// share a single transport so that connections are pooled across them,
+// and a TransportConfig can be used to create a transport for networks
+// that need a proxy or their own certificate authorities.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TransportConfig configures the HTTP transport used to connect to
+// Wikibase and SPARQL endpoints.
+type TransportConfig struct {
+	// MaxIdleConns is the maximum number of idle connections kept
+	// across all hosts. Zero means no limit.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the maximum number of idle connections
+	// kept for each host. It should be at least the number of workers
+	// requesting provenance so that their connections are reused.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept.
+	IdleConnTimeout time.Duration
+	// DialTimeout limits how long a connection takes to be made.
+	DialTimeout time.Duration
+	// KeepAlive is the interval between keep-alive probes.
+	KeepAlive time.Duration
+	// TLSHandshakeTimeout limits how long a TLS handshake takes.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout limits how long we wait for a server to
+	// respond once a request has been sent. It should be longer than
+	// the time a SPARQL endpoint allows a query to run for.
+	ResponseHeaderTimeout time.Duration
+	// DisableHTTP2 restricts connections to HTTP/1.1.
+	DisableHTTP2 bool
+	// Proxy is the URL of the proxy to connect through. When it is
+	// empty the proxy is read from the environment, i.e. HTTPS_PROXY,
+	// HTTP_PROXY, and NO_PROXY.
+	Proxy string
+	// CAFile is a PEM encoded bundle of certificate authorities trusted
+	// alongside those of the system.
+	CAFile string
+}
+
+// DefaultTransportConfig configures the transport shared by clients
+// created with NewClient.
+var DefaultTransportConfig = TransportConfig{
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   10,
+	IdleConnTimeout:       90 * time.Second,
+	DialTimeout:           30 * time.Second,
+	KeepAlive:             30 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 120 * time.Second,
+}
+
+// sharedHTTPClient is used by clients that haven't been given their
+// own http.Client.
+var sharedHTTPClient *http.Client
+
+// NewTransport creates an http.Transport from the configuration. An
+// error is returned if the proxy URL or certificate authorities cannot
+// be used.
+func (config TransportConfig) NewTransport() (*http.Transport, error) {
+	proxy := http.ProxyFromEnvironment
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL: '%s'", config.Proxy)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	dialer := &net.Dialer{
+		Timeout:   config.DialTimeout,
+		KeepAlive: config.KeepAlive,
+	}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     !config.DisableHTTP2,
+	}
+	if config.DisableHTTP2 {
+		// A non-nil, empty map disables HTTP/2.
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if config.CAFile != "" {
+		pool, err := loadCertPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return transport, nil
+}
+
+// NewHTTPClient creates an http.Client using a transport created from
+// the configuration.
+func (config TransportConfig) NewHTTPClient() (*http.Client, error) {
+	transport, err := config.NewTransport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// loadCertPool returns the system's certificate authorities with those
+// in the given PEM file added.
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read certificate authorities: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in: '%s'", caFile)
+	}
+	return pool, nil
+}
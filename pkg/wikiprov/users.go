@@ -0,0 +1,120 @@
+package wikiprov
+
+// Looking up the users who made revisions, e.g. whether they are bots.
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// botGroup is the user group of bots.
+const botGroup = "bot"
+
+// maxUsersPerRequest is the most users whose groups Wikibase will
+// return in a single request for most users.
+const maxUsersPerRequest = 50
+
+// wdUsers describes the groups of users.
+type wdUsers struct {
+	Query struct {
+		Users []struct {
+			Name    string   `json:"name"`
+			Groups  []string `json:"groups"`
+			Missing apiFlag  `json:"missing"`
+			Invalid apiFlag  `json:"invalid"`
+		} `json:"users"`
+	} `json:"query"`
+}
+
+// botCache records which users of each Wikibase are bots.
+type botCache struct {
+	mutex sync.Mutex
+	bots  map[string]bool
+}
+
+// bots is shared by every client so that users are only looked up once.
+var bots = &botCache{bots: make(map[string]bool)}
+
+// key returns the key a user of a Wikibase is recorded under.
+func (cache *botCache) key(api string, user string) string {
+	return fmt.Sprintf("%s\x00%s", api, canonicalUser(user))
+}
+
+// lookup tells the caller whether the user is a bot and whether we know
+// either way.
+func (cache *botCache) lookup(api string, user string) (bool, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	bot, ok := cache.bots[cache.key(api, user)]
+	return bot, ok
+}
+
+// set records whether the user is a bot.
+func (cache *botCache) set(api string, user string, bot bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.bots[cache.key(api, user)] = bot
+}
+
+// matcher returns a function telling the caller whether a revision
+// should be returned given the options. Where bots are excluded the
+// users who made the revisions must have been looked up first with
+// lookupBots.
+func (client *Client) matcher(opts Options) func(Revision) bool {
+	if !opts.ExcludeBots {
+		return opts.matches
+	}
+	return func(rev Revision) bool {
+		if !opts.matches(rev) {
+			return false
+		}
+		bot, _ := bots.lookup(client.APIURL, rev.User)
+		return !bot
+	}
+}
+
+// lookupBots finds out which of the users who made the revisions are
+// bots, requesting the groups of those we don't already know about.
+func (client *Client) lookupBots(ctx context.Context, revs []revision) error {
+	var users []string
+	seen := make(map[string]bool)
+	for _, rev := range revs {
+		user := canonicalUser(rev.User)
+		if user == "" || seen[user] || anonymous(rev.typed()) {
+			continue
+		}
+		seen[user] = true
+		if _, ok := bots.lookup(client.APIURL, user); !ok {
+			users = append(users, user)
+		}
+	}
+	for start := 0; start < len(users); start += maxUsersPerRequest {
+		end := start + maxUsersPerRequest
+		if end > len(users) {
+			end = len(users)
+		}
+		query := url.Values{}
+		query.Set("format", format)
+		query.Set("action", action)
+		query.Set("list", "users")
+		query.Set("ususers", strings.Join(users[start:end], "|"))
+		query.Set("usprop", "groups")
+		var groups wdUsers
+		if err := client.newPaginator(query).next(ctx, &groups); err != nil {
+			return fmt.Errorf("looking up the groups of users: %w", err)
+		}
+		for _, user := range groups.Query.Users {
+			bot := false
+			for _, group := range user.Groups {
+				if group == botGroup {
+					bot = true
+				}
+			}
+			bots.set(client.APIURL, user.Name, bot)
+		}
+	}
+	return nil
+}
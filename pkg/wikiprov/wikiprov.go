@@ -8,7 +8,7 @@
 //
 // An example API query we need to construct:
 //
-//	https://www.wikidata.org/w/api.php?action=query&format=json&prop=revisions&titles=Q5381415&rvlimit=200&rvprop=ids|user|comment|timestamp|sha1
+//	https://www.wikidata.org/w/api.php?action=query&format=json&prop=revisions|info&titles=Q5381415&rvlimit=200&rvprop=ids|user|comment|timestamp|sha1
 //
 // We'll also use some of these values to build a permalink for that
 // provenance struct which looks as follows:
@@ -16,18 +16,29 @@
 //	https://www.wikidata.org/w/index.php?title=Q178051&oldid=1301912874&format=json
 //
 //	https://www.wikidata.org/w/index.php?title=<QID>&oldid=<REVISION>&format=json
+//
+// Package level functions talk to Wikidata by default. A Client can be
+// created with NewClient for each Wikibase instance the caller needs to
+// talk to.
 package wikiprov
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// FullHistory can be supplied as the length of history to retrieve
+// every revision of an entity, up to the client's MaxHistory.
+const FullHistory = -1
+
 func getRevisionProperties() string {
 	return strings.Join(revisionPropertiesDefault[:], "|")
 }
@@ -43,97 +54,386 @@ func getRevisionProperties() string {
 //		https://www.wikidata.org/w/api.php?
 //		   action=query
 //		   &format=json
-//		   &prop=revisions
+//		   &prop=revisions|info
 //		   &rvlimit=1
 //		   &rvprop=ids|user|comment|timestamp|sha1
 //		   &titles=item:Q12345
-func buildRequest(id string, history int) (*http.Request, error) {
+func (client *Client) buildRequest(ctx context.Context, id string, history int) (*http.Request, error) {
+	props, err := client.revisionProperties(Options{})
+	if err != nil {
+		return nil, err
+	}
+	return client.newRequest(ctx, buildQuery(id, history, props))
+}
+
+// buildQuery returns the query parameters needed to request the
+// revision history of an entity from Wikibase. props is the value of
+// rvprop.
+func buildQuery(id string, history int, props string) url.Values {
 	const paramFormat = "format"
 	const paramAction = "action"
 	const paramTitles = "titles"
 	const paramProps = "prop"
-	const paramLimit = "rvlimit"
 	const paramRevisionProp = "rvprop"
 	const itemPrefix = "item:"
 
-	req, err := http.NewRequest("GET", wikibaseAPI, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	query := req.URL.Query()
+	query := url.Values{}
 	query.Set(paramFormat, format)
 	query.Set(paramAction, action)
 	query.Set(paramTitles, fmt.Sprintf("%s%s", itemPrefix, id))
 	query.Set(paramProps, prop)
 	query.Set(paramLimit, fmt.Sprintf("%d", history))
-	query.Set(paramRevisionProp, getRevisionProperties())
+	query.Set(paramRevisionProp, props)
+	return query
+}
 
+// newRequest creates a request for the client's Wikibase API from the
+// given query parameters.
+func (client *Client) newRequest(ctx context.Context, query url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", client.APIURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if client.MaxLag > 0 {
+		query.Set(paramMaxLag, strconv.Itoa(client.MaxLag))
+	}
 	req.URL.RawQuery = query.Encode()
+	req.Header.Add("User-Agent", client.Agent)
+	return req, nil
+}
 
-	req.Header.Add("User-Agent", agent)
+// doRequest sends a request to Wikibase and returns the body of the
+// response if it was successful. Requests that fail for reasons that
+// might be temporary are retried according to the client's retry
+// policy. Each attempt waits on the client's rate limiter.
+func (client *Client) doRequest(ctx context.Context, request *http.Request) ([]byte, error) {
+	attempts := client.Retry.attempts()
+	for attempt := 1; ; attempt++ {
+		if err := client.RateLimiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		data, err := client.attemptRequest(request)
+		if err == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		temp, ok := err.(temporaryError)
+		if !ok {
+			return nil, err
+		}
+		if client.OnThrottle != nil && throttling(temp.err) {
+			client.OnThrottle(temp.err)
+		}
+		if attempt >= attempts {
+			if attempt > 1 {
+				return nil, &RetryError{Attempts: attempt, Err: temp.err}
+			}
+			return nil, temp.err
+		}
+		if err := sleepContext(ctx, client.Retry.delay(attempt, temp.retryAfter)); err != nil {
+			return nil, err
+		}
+	}
+}
 
-	return req, nil
+// attemptRequest makes a single attempt at a request to Wikibase,
+// returning a temporaryError if the request can be tried again.
+func (client *Client) attemptRequest(request *http.Request) ([]byte, error) {
+
+	resp, err := client.httpClient().Do(request)
+	if err != nil {
+		return nil, temporaryError{err: err}
+	}
+	defer resp.Body.Close()
+
+	const expectedCode int = 200
+	if resp.StatusCode != expectedCode {
+		excerpt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		var err error = &StatusError{Code: resp.StatusCode, Body: string(excerpt)}
+		retryAfter := parseRetryAfter(resp.Header)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			err = &RateLimitError{RetryAfter: retryAfter, Err: err}
+		}
+		if retryableStatus(resp.StatusCode) {
+			return nil, temporaryError{err: err, retryAfter: retryAfter}
+		}
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, temporaryError{err: err}
+	}
+
+	var apiErr apiErrorResponse
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Code != "" {
+		err := &APIError{Code: apiErr.Error.Code, Info: apiErr.Error.Info}
+		if apiErr.Error.Code == paramMaxLag {
+			retryAfter := parseRetryAfter(resp.Header)
+			return nil, temporaryError{
+				err:        &RateLimitError{RetryAfter: retryAfter, Err: err},
+				retryAfter: retryAfter,
+			}
+		}
+		return nil, err
+	}
+
+	return data, nil
 }
 
 // GetWikidataProvenance requests the entity data we need from the
 // Wikibase API and returns a structure containing the information that
 // we're interested in, augmented with a permalink to the record.
+//
+// lenHistory can be set to FullHistory to retrieve every revision of
+// the entity.
 func GetWikidataProvenance(id string, lenHistory int) (Provenance, error) {
+	return defaultClient.GetWikidataProvenance(id, lenHistory)
+}
 
-	if lenHistory < 1 {
+// GetWikidataProvenanceContext is GetWikidataProvenance with a context
+// that can be used to cancel the request or set a deadline for it.
+func GetWikidataProvenanceContext(ctx context.Context, id string, lenHistory int) (Provenance, error) {
+	return defaultClient.GetWikidataProvenanceContext(ctx, id, lenHistory)
+}
+
+// GetWikidataProvenance requests the entity data we need from the
+// client's Wikibase API and returns a structure containing the
+// information that we're interested in, augmented with a permalink to
+// the record.
+func (client *Client) GetWikidataProvenance(id string, lenHistory int) (Provenance, error) {
+	return client.GetWikidataProvenanceContext(context.Background(), id, lenHistory)
+}
+
+// GetWikidataProvenanceContext is the client's GetWikidataProvenance
+// with a context that can be used to cancel the request or set a
+// deadline for it. The context's error is returned if it is done
+// before provenance is retrieved.
+func (client *Client) GetWikidataProvenanceContext(ctx context.Context, id string, lenHistory int) (Provenance, error) {
+	return client.GetProvenance(ctx, id, Options{History: lenHistory})
+}
+
+// GetProvenance requests provenance for an entity from the default
+// client configured with the given options.
+func GetProvenance(ctx context.Context, id string, opts Options) (Provenance, error) {
+	return defaultClient.GetProvenance(ctx, id, opts)
+}
+
+// GetProvenance requests provenance for an entity from the client's
+// Wikibase API configured with the given options. The context's error
+// is returned if it is done before provenance is retrieved.
+//
+// Where more history is requested than Wikibase will return in a
+// single response, the API's continuation is followed until the
+// history is complete.
+//
+// When the client has a Cache, provenance that is still current is
+// returned from it. See Client.Cache.
+func (client *Client) GetProvenance(ctx context.Context, id string, opts Options) (Provenance, error) {
+	if client.Cache != nil {
+		return client.cachedProvenance(ctx, id, opts)
+	}
+	prov, _, err := client.getProvenance(ctx, id, opts)
+	return prov, err
+}
+
+// getProvenance requests provenance for an entity from Wikibase,
+// without consulting the cache. The entity's latest revision, i.e. its
+// lastrevid, is returned alongside its provenance where it is known.
+func (client *Client) getProvenance(ctx context.Context, id string, opts Options) (Provenance, int, error) {
+
+	limit := opts.History
+	if limit == FullHistory {
+		limit = client.MaxHistory
+	} else if limit < 1 {
 		// No history requested. Nothing to do.
-		return Provenance{}, nil
+		return Provenance{}, 0, nil
 	}
 
-	request, err := buildRequest(id, lenHistory)
+	props, err := client.revisionProperties(opts)
 	if err != nil {
-		return Provenance{}, err
+		return Provenance{}, 0, err
 	}
 
-	var client http.Client
-	resp, err := client.Do(request)
-	if err != nil {
-		return Provenance{}, fmt.Errorf(
-			"retreivng provenance from Wikibase endpoint for: %s: %v (history len: '%d')",
-			id,
-			err,
-			lenHistory,
-		)
+	query := buildQuery(id, opts.revisionsPerRequest(limit), props)
+	if start := opts.start(); start != "" {
+		query.Set(paramStart, start)
+		query.Set(paramDirection, directionOlder)
 	}
+	pages := client.newPaginator(query)
+	match := client.matcher(opts)
 
-	const retryHeader = "Retry-After"
-	if len(resp.Header[retryHeader]) > 0 {
-		retry, _ := strconv.Atoi(resp.Header[retryHeader][0])
-		if retry > 0 {
-			time.Sleep(time.Duration(retry))
-			return GetWikidataProvenance(id, lenHistory)
+	var history wdRevisions
+	for pages.more() {
+		var page wdRevisions
+		err := pages.next(ctx, &page)
+		if err != nil {
+			return Provenance{}, 0, fmt.Errorf(
+				"retrieving provenance from Wikibase endpoint for: %s: %w (history len: '%d')",
+				id,
+				err,
+				opts.History,
+			)
+		}
+		added := history.merge(page)
+		if opts.ExcludeBots {
+			_, revs := page.page()
+			if err := client.lookupBots(ctx, revs.Revisions); err != nil {
+				return Provenance{}, 0, fmt.Errorf(
+					"retrieving provenance from Wikibase endpoint for: %s: %w",
+					id,
+					err,
+				)
+			}
+		}
+		found := history.count(match)
+		if limit > 0 && found >= limit {
+			break
 		}
+		if added == 0 {
+			// Wikibase isn't returning anything new, so stop here.
+			break
+		}
+		if history.reaches(opts.Since) {
+			// Revisions are listed newest first so none still to come
+			// were made since the time asked for.
+			break
+		}
+		pages.query.Set(paramLimit, fmt.Sprintf("%d", opts.revisionsPerRequest(limit-found)))
 	}
 
-	const expectedCode int = 200
-	if resp.StatusCode != expectedCode {
-		return Provenance{}, fmt.Errorf(
-			"incorrect status retrieving history from Wikibase endpoint: '%d': expected '%d' (history len: '%d')",
-			resp.StatusCode,
-			expectedCode,
-			lenHistory,
-		)
+	_, page := history.page()
+	if page.Missing || page.Invalid {
+		prov, err := client.notFound(ctx, id, page)
+		return prov, 0, err
 	}
 
-	data, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		return Provenance{}, err
+	if !opts.AsOf.IsZero() && len(page.Revisions) == 0 {
+		// The entity exists but had no revisions at the time asked
+		// for, i.e. it had yet to be created.
+		return Provenance{Title: id, AsOf: opts.asOf()}, 0, &NotFoundError{Title: id, AsOf: opts.AsOf}
 	}
 
-	var wdRevisions wdRevisions
-	err = json.Unmarshal(data, &wdRevisions)
-	if err != nil {
-		return Provenance{}, err
+	prov := history.normalize(client)
+	if opts.AsOf.IsZero() && !opts.Until.IsZero() && page.LastRevID != 0 &&
+		(len(page.Revisions) == 0 || page.Revisions[0].RevisionID != page.LastRevID) {
+		// The entity has been edited since Until, and provenance still
+		// describes its latest revision.
+		if err := client.latest(ctx, id, props, &prov); err != nil {
+			return Provenance{}, 0, fmt.Errorf(
+				"retrieving latest revision from Wikibase endpoint for: %s: %w",
+				id,
+				err,
+			)
+		}
+	}
+	prov.AsOf = opts.asOf()
+	if opts.Creation && !(bool(page.Redirect) && opts.FollowRedirects) {
+		if err := client.creation(ctx, id, page, &prov); err != nil {
+			return Provenance{}, 0, fmt.Errorf(
+				"retrieving creation from Wikibase endpoint for: %s: %w",
+				id,
+				err,
+			)
+		}
+	}
+	prov.Revisions = filterRevisions(prov.Revisions, limit, match)
+	if client.RenderHistory {
+		prov.History = prov.HistoryStrings()
+	}
+
+	if page.Redirect {
+		// A redirect has no entity of its own to take a snapshot of.
+		redirected, err := client.redirect(ctx, prov, opts)
+		return redirected, page.LastRevID, err
+	}
+	if opts.Snapshot {
+		snapshot, err := client.GetSnapshot(ctx, prov.Title, prov.Revision)
+		if err != nil {
+			// The provenance retrieved is still returned, with the error
+			// recorded against it, so that it isn't lost.
+			prov.Error = fmt.Errorf(
+				"retrieving snapshot from Wikibase endpoint for: %s: %w (revision: '%d')",
+				id,
+				err,
+				prov.Revision,
+			)
+			return prov, page.LastRevID, prov.Error
+		}
+		prov.Snapshot = snapshot
+	}
+	return prov, page.LastRevID, nil
+}
+
+// creation sets the date the entity was created and the user that
+// created it from its first revision. If the history already retrieved
+// reaches back to the first revision, i.e. one without a parent, it is
+// used, otherwise the first revision is requested from Wikibase with
+// the default revision properties.
+func (client *Client) creation(ctx context.Context, id string, page revisions, prov *Provenance) error {
+	if len(page.Revisions) > 0 {
+		if first := page.Revisions[len(page.Revisions)-1]; first.ParentID == 0 && first.User != "" {
+			prov.Created, prov.Creator = first.Timestamp, first.User
+			return nil
+		}
+	}
+	query := buildQuery(id, 1, getRevisionProperties())
+	query.Set(paramDirection, directionNewer)
+	var first wdRevisions
+	if err := client.newPaginator(query).next(ctx, &first); err != nil {
+		return err
+	}
+	_, firstPage := first.page()
+	if len(firstPage.Revisions) > 0 {
+		prov.Created = firstPage.Revisions[0].Timestamp
+		prov.Creator = firstPage.Revisions[0].User
+	}
+	return nil
+}
+
+// latest sets the title, revision, and permalink of the provenance to
+// describe the latest revision of the entity, requesting it from
+// Wikibase. It is used when the revisions already retrieved don't
+// include the latest, e.g. when they were listed back from Until.
+func (client *Client) latest(ctx context.Context, id string, props string, prov *Provenance) error {
+	var results wdRevisions
+	if err := client.newPaginator(buildQuery(id, 1, props)).next(ctx, &results); err != nil {
+		return err
 	}
+	latest := results.normalize(client)
+	if latest.Revision == 0 {
+		return nil
+	}
+	prov.Title, prov.Entity = latest.Title, latest.Entity
+	prov.Revision, prov.Modified, prov.Permalink = latest.Revision, latest.Modified, latest.Permalink
+	return nil
+}
+
+// revisionsPerRequest returns the number of revisions to ask Wikibase
+// for in a single request given how many are still needed. Zero means
+// there is no limit on the number needed. When revisions are filtered
+// we can't know how many we need so we ask for as many as we can.
+func (opts Options) revisionsPerRequest(needed int) int {
+	if opts.filtered() || needed < 1 || needed > maxRevisionsPerRequest {
+		return maxRevisionsPerRequest
+	}
+	return needed
+}
 
-	return wdRevisions.normalize(), nil
+// sleepContext pauses for the given duration or until the context is
+// done, whichever happens first. The context's error is returned if it
+// ends the sleep early.
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
 
 // Version returns the agent string for this package.
@@ -144,30 +444,29 @@ func Version() string {
 // SetWikibaseURLs sets the URL for this package to connect to. E.g.
 // newURL would point to Wikidata or a custom Wikibase instance.
 func SetWikibaseURLs(newURL string) {
-	wikibaseAPI = constructWikibaseAPIURL(newURL)
-	wikibasePermalinkBase = constructWikibaseIndexURL(newURL)
+	defaultClient.SetWikibaseURLs(newURL)
 }
 
 // SetWikibaseAPIURL lets the caller configure its own Wikibase API
 // service to connect to.
 func SetWikibaseAPIURL(newURL string) {
-	wikibaseAPI = constructWikibaseAPIURL(newURL)
+	defaultClient.APIURL = constructWikibaseAPIURL(newURL)
 }
 
 // SetWikibasePermalinkBaseURL lets the caller configure the Wikibase
 // base URL for the permalink that needs to be built.
 func SetWikibasePermalinkBaseURL(newURL string) {
-	wikibasePermalinkBase = constructWikibaseIndexURL(newURL)
+	defaultClient.PermalinkBase = constructWikibaseIndexURL(newURL)
 }
 
 // GetWikibaseAPIURL lets the caller configure its own Wikibase API
 // service to connect to.
 func GetWikibaseAPIURL() string {
-	return wikibaseAPI
+	return defaultClient.APIURL
 }
 
 // GetWikibaseIndexURL lets the caller configure its own Wikibase API
 // service to connect to.
 func GetWikibaseIndexURL() string {
-	return wikibasePermalinkBase
+	return defaultClient.PermalinkBase
 }
@@ -0,0 +1,255 @@
+package wikiprov
+
+// Errors returned by wikiprov. Each error type can be inspected using
+// errors.As, or matched against its sentinel value using errors.Is,
+// e.g. errors.Is(err, ErrRateLimited), so that callers can tell the
+// difference between an entity that doesn't exist and a Wikibase that
+// is unavailable.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Sentinel values that the errors returned by wikiprov can be matched
+// against using errors.Is.
+var (
+	ErrNotFound    = errors.New("wikiprov: entity not found")
+	ErrRateLimited = errors.New("wikiprov: rate limited")
+	ErrStatus      = errors.New("wikiprov: unexpected status")
+	ErrAPI         = errors.New("wikiprov: api error")
+	ErrDecode      = errors.New("wikiprov: cannot decode response")
+	// ErrRevisionProperty is returned when a revision property is
+	// requested that wikiprov doesn't know about.
+	ErrRevisionProperty = errors.New("wikiprov: unknown revision property")
+	// ErrFixity is returned when the content of a revision doesn't
+	// match its SHA1.
+	ErrFixity = errors.New("wikiprov: fixity check failed")
+)
+
+// NotFoundError is returned when Wikibase reports that the entity
+// requested doesn't exist, e.g. it has been deleted or never existed.
+// Deleted is set when Wikibase has a record of its deletion. AsOf is set
+// when the entity didn't exist at the time provenance was requested as
+// of.
+type NotFoundError struct {
+	Title   string
+	Deleted bool
+	AsOf    time.Time
+}
+
+// Error implements the error interface for NotFoundError.
+func (err *NotFoundError) Error() string {
+	if err.Deleted {
+		return fmt.Sprintf("entity deleted from Wikibase: '%s'", err.Title)
+	}
+	if !err.AsOf.IsZero() {
+		return fmt.Sprintf(
+			"entity not found in Wikibase as of %s: '%s'",
+			err.AsOf.UTC().Format(time.RFC3339),
+			err.Title,
+		)
+	}
+	return fmt.Sprintf("entity not found in Wikibase: '%s'", err.Title)
+}
+
+// Is enables NotFoundError to be matched with ErrNotFound.
+func (err *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// RateLimitError is returned when Wikibase asks us to slow down, e.g.
+// with a 429 status or a maxlag error. RetryAfter is how long Wikibase
+// asked us to wait, if it told us. Err describes the response.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+// Error implements the error interface for RateLimitError.
+func (err *RateLimitError) Error() string {
+	if err.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited by Wikibase (retry after: %s): %s", err.RetryAfter, err.Err)
+	}
+	return fmt.Sprintf("rate limited by Wikibase: %s", err.Err)
+}
+
+// Is enables RateLimitError to be matched with ErrRateLimited.
+func (err *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Unwrap returns the error describing the response.
+func (err *RateLimitError) Unwrap() error {
+	return err.Err
+}
+
+// StatusError is returned when Wikibase responds with an unexpected
+// HTTP status. Body is an excerpt of the response.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+// Error implements the error interface for StatusError.
+func (err *StatusError) Error() string {
+	return fmt.Sprintf(
+		"incorrect status retrieving history from Wikibase endpoint: '%d': expected '%d'",
+		err.Code,
+		200,
+	)
+}
+
+// Is enables StatusError to be matched with ErrStatus.
+func (err *StatusError) Is(target error) bool {
+	return target == ErrStatus
+}
+
+// APIError is returned when the MediaWiki API responds with an error,
+// e.g.
+//
+//	{
+//		"error": {
+//			"code": "badvalue",
+//			"info": "Unrecognized value for parameter \"prop\": revision."
+//		}
+//	}
+type APIError struct {
+	Code string
+	Info string
+}
+
+// Error implements the error interface for APIError.
+func (err *APIError) Error() string {
+	return fmt.Sprintf("error from Wikibase API: '%s': %s", err.Code, err.Info)
+}
+
+// Is enables APIError to be matched with ErrAPI.
+func (err *APIError) Is(target error) bool {
+	return target == ErrAPI
+}
+
+// DecodeError is returned when a response from Wikibase cannot be
+// decoded.
+type DecodeError struct {
+	Err error
+}
+
+// Error implements the error interface for DecodeError.
+func (err *DecodeError) Error() string {
+	return fmt.Sprintf("cannot decode response from Wikibase: %s", err.Err)
+}
+
+// Is enables DecodeError to be matched with ErrDecode.
+func (err *DecodeError) Is(target error) bool {
+	return target == ErrDecode
+}
+
+// Unwrap returns the error from the decoder.
+func (err *DecodeError) Unwrap() error {
+	return err.Err
+}
+
+// FixityError is returned when revisions of an entity cannot be
+// verified, e.g. the SHA1 of their content doesn't match that recorded.
+// Revisions lists the revisions that failed. See Verification.
+type FixityError struct {
+	Title     string
+	Revisions []int
+}
+
+// Error implements the error interface for FixityError.
+func (err *FixityError) Error() string {
+	return fmt.Sprintf("fixity check failed for: '%s' (revisions: %v)", err.Title, err.Revisions)
+}
+
+// Is enables FixityError to be matched with ErrFixity.
+func (err *FixityError) Is(target error) bool {
+	return target == ErrFixity
+}
+
+// RetryError is returned when a request has failed every time it was
+// attempted. Err is the error from the final attempt.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+// Error implements the error interface for RetryError.
+func (err *RetryError) Error() string {
+	return fmt.Sprintf("%s (attempts: '%d')", err.Err, err.Attempts)
+}
+
+// Unwrap returns the error from the final attempt.
+func (err *RetryError) Unwrap() error {
+	return err.Err
+}
+
+// Classes of error returned by ErrorClass.
+const (
+	ClassNotFound    = "not_found"
+	ClassRateLimited = "rate_limited"
+	ClassStatus      = "http_status"
+	ClassAPI         = "api_error"
+	ClassDecode      = "decode_error"
+	ClassFixity      = "fixity"
+	ClassCancelled   = "cancelled"
+	ClassNetwork     = "network"
+	ClassUnknown     = "unknown"
+)
+
+// ErrorClass returns a short description of the class of an error
+// returned by wikiprov, e.g. so that it can be reported or used to
+// decide whether to alert. An empty string is returned for nil.
+func ErrorClass(err error) string {
+	var urlErr *url.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrNotFound):
+		return ClassNotFound
+	case errors.Is(err, ErrRateLimited):
+		return ClassRateLimited
+	case errors.Is(err, ErrStatus):
+		return ClassStatus
+	case errors.Is(err, ErrAPI):
+		return ClassAPI
+	case errors.Is(err, ErrDecode):
+		return ClassDecode
+	case errors.Is(err, ErrFixity):
+		return ClassFixity
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ClassCancelled
+	case errors.As(err, &urlErr):
+		return ClassNetwork
+	}
+	return ClassUnknown
+}
+
+// ErrorAttempts returns the number of times a request was attempted
+// before the error was returned.
+func ErrorAttempts(err error) int {
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) {
+		return retryErr.Attempts
+	}
+	return 1
+}
+
+// ErrorSummary is a serializable description of an error.
+type ErrorSummary struct {
+	Class   string `json:"Class"`
+	Message string `json:"Message"`
+}
+
+// summarizeError returns an ErrorSummary for the error, or nil if
+// there isn't one.
+func summarizeError(err error) *ErrorSummary {
+	if err == nil {
+		return nil
+	}
+	return &ErrorSummary{Class: ErrorClass(err), Message: err.Error()}
+}
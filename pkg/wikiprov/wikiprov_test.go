@@ -1,20 +1,27 @@
 package wikiprov
 
 import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // testInit allows us to reset any values we need to reset before our
 // next test... The use of testInit() might point to a different pattern
 // that we can use in another release.
 func testInit() {
-	wikibaseAPI = constructWikibaseAPIURL(defaultBaseURI)
-	wikibasePermalinkBase = constructWikibaseIndexURL(defaultBaseURI)
+	defaultClient = NewClient(defaultBaseURI)
 }
 
 // TestConstructAPIURL ensures that we correctly create the URL needed
@@ -61,8 +68,10 @@ func TestGetWikidataProvenance(t *testing.T) {
 	}))
 	defer func() { testServer.Close() }()
 
-	// Replace Wikibase API URL with that of the test server.
-	wikibaseAPI = testServer.URL
+	// Replace Wikibase API URL with that of the test server and ask
+	// for the history to be rendered as strings.
+	defaultClient.APIURL = testServer.URL
+	defaultClient.RenderHistory = true
 
 	// The integer here 1,000,000 is related to number of lines to request
 	// from Wikidata. Wikidata handles that. We don't get to control the
@@ -131,7 +140,7 @@ func TestGetWikidataProvenanceError(t *testing.T) {
 	defer func() { testServer.Close() }()
 
 	// Replace Wikibase API URL with that of the test server.
-	wikibaseAPI = testServer.URL
+	defaultClient.APIURL = testServer.URL
 
 	// The integer here 1,000,000 is related to number of lines to request
 	// from Wikidata. Wikidata handles that. We don't get to control the
@@ -158,14 +167,14 @@ func TestBuildRequest(t *testing.T) {
 
 	testInit()
 
-	req, err := buildRequest("Q12345", 1)
+	req, err := defaultClient.buildRequest(context.Background(), "Q12345", 1)
 	if err != nil {
 		t.Errorf("Expected 'nil' err from buildREquest, received: '%s'", err)
 	}
 
 	// The request to get provenance for the given QID, in this case,
 	// QID needs to be:
-	const expectedURL string = "https://www.wikidata.org/w/api.php?action=query&format=json&prop=revisions&rvlimit=1&rvprop=ids%7Cuser%7Ccomment%7Ctimestamp%7Csha1&titles=item%3AQ12345"
+	const expectedURL string = "https://www.wikidata.org/w/api.php?action=query&format=json&prop=revisions%7Cinfo&rvlimit=1&rvprop=ids%7Cuser%7Ccomment%7Ctimestamp%7Csha1&titles=item%3AQ12345"
 	// We test that here...
 	if req.URL.String() != expectedURL {
 		t.Errorf("Requested string we built isn't correct, \nreceived: '%s', \nexpected: '%s'",
@@ -188,3 +197,1404 @@ func TestBuildRequest(t *testing.T) {
 	}
 
 }
+
+// TestClientsConcurrently ensures that two clients configured for two
+// different Wikibase instances can be used at the same time without
+// the configuration of one leaking into the other.
+func TestClientsConcurrently(t *testing.T) {
+
+	serverA := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(testJSON))
+	}))
+	defer func() { serverA.Close() }()
+
+	serverB := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(testJSON))
+	}))
+	defer func() { serverB.Close() }()
+
+	clientA := NewClient("http://a.example.com")
+	clientA.APIURL = serverA.URL
+	clientB := NewClient("http://b.example.com/")
+	clientB.APIURL = serverB.URL
+	clientB.EntityURI = "http://b.example.com/entity/"
+
+	const permalinkA = "http://a.example.com/w/index.php?oldid=1419131078&title=Q12345"
+	const permalinkB = "http://b.example.com/w/index.php?oldid=1419131078&title=Q12345"
+	const entityB = "http://b.example.com/entity/Q12345"
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 10; idx++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			prov, err := clientA.GetWikidataProvenance("Q12345", 5)
+			if err != nil {
+				t.Errorf("Unexpected error from client A: %s", err)
+			}
+			if prov.Permalink != permalinkA {
+				t.Errorf("Client A permalink '%s' is incorrect, expected: '%s'", prov.Permalink, permalinkA)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			prov, err := clientB.GetWikidataProvenance("Q12345", 5)
+			if err != nil {
+				t.Errorf("Unexpected error from client B: %s", err)
+			}
+			if prov.Permalink != permalinkB {
+				t.Errorf("Client B permalink '%s' is incorrect, expected: '%s'", prov.Permalink, permalinkB)
+			}
+			if prov.Entity != entityB {
+				t.Errorf("Client B entity '%s' is incorrect, expected: '%s'", prov.Entity, entityB)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestGetWikidataProvenanceContext ensures that a request is abandoned
+// when its context is done, e.g. when a Wikibase is slow to respond or
+// is sending Retry-After headers that we can no longer honor.
+func TestGetWikidataProvenanceContext(t *testing.T) {
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Retry-After", "1000000000")
+		res.WriteHeader(429)
+	}))
+	defer func() { testServer.Close() }()
+
+	client := NewClient(defaultBaseURI)
+	client.APIURL = testServer.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	prov, err := client.GetWikidataProvenanceContext(ctx, "Q12345", 5)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context deadline to be returned, received: '%v'", err)
+	}
+
+	nilProv := Provenance{}
+	if !reflect.DeepEqual(prov, nilProv) {
+		t.Errorf("Function should return empty Provenance{} returned: %s", prov)
+	}
+}
+
+// newPagedTestServer returns a test server that returns a revision
+// history across three pages of results, following the continuation
+// parameter sent by the client.
+func newPagedTestServer(requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		*requests++
+		res.WriteHeader(200)
+		switch req.URL.Query().Get("rvcontinue") {
+		case "":
+			res.Write([]byte(testPageOne))
+		case "20210511165245|1419073622":
+			res.Write([]byte(testPageTwo))
+		default:
+			res.Write([]byte(testPageThree))
+		}
+	}))
+}
+
+// fullHistoryTest and fullHistoryTests provide table-driven testing of
+// the continuation of revision history below.
+type fullHistoryTest struct {
+	lenHistory int
+	maxHistory int
+	expected   int
+	requests   int
+}
+
+var fullHistoryTests = []fullHistoryTest{
+	{FullHistory, 0, 5, 3},
+	{FullHistory, 3, 3, 2},
+	{FullHistory, 2, 2, 1},
+	{4, 0, 4, 2},
+	{2, 0, 2, 1},
+	{10, 0, 5, 3},
+}
+
+// TestGetFullHistory ensures that the API's continuation is followed
+// to retrieve history beyond the first page of results, stopping when
+// the history is exhausted or when enough history has been retrieved.
+func TestGetFullHistory(t *testing.T) {
+	for _, test := range fullHistoryTests {
+		requests := 0
+		testServer := newPagedTestServer(&requests)
+		client := NewClient(defaultBaseURI)
+		client.APIURL = testServer.URL
+		client.MaxHistory = test.maxHistory
+		prov, err := client.GetWikidataProvenance("Q12345", test.lenHistory)
+		testServer.Close()
+		if err != nil {
+			t.Errorf("Unexpected error retrieving history: %s", err)
+		}
+		if len(prov.Revisions) != test.expected {
+			t.Errorf("History length '%d' is incorrect, expected: '%d' (%+v)", len(prov.Revisions), test.expected, test)
+		}
+		if requests != test.requests {
+			t.Errorf("Number of requests '%d' is incorrect, expected: '%d' (%+v)", requests, test.requests, test)
+		}
+		for idx, val := range prov.Revisions {
+			testUser := fmt.Sprintf("user%d", idx+1)
+			if val.User != testUser {
+				t.Errorf("History out of order, expected '%s' received '%s'", testUser, val.User)
+			}
+		}
+	}
+}
+
+// TestRevisions ensures that revisions are returned to the caller as
+// typed records and that pre-formatted strings are only returned when
+// they are asked for.
+func TestRevisions(t *testing.T) {
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(200)
+		res.Write([]byte(testRevisionJSON))
+	}))
+	defer func() { testServer.Close() }()
+
+	client := NewClient(defaultBaseURI)
+	client.APIURL = testServer.URL
+
+	prov, err := client.GetWikidataProvenance("Q12345", 2)
+	if err != nil {
+		t.Errorf("Unexpected error retrieving history: %s", err)
+	}
+
+	expected := []Revision{
+		{
+			RevisionID: 1419131078,
+			ParentID:   1419073806,
+			User:       "user1",
+			Timestamp:  time.Date(2021, time.May, 11, 20, 17, 31, 0, time.UTC),
+			SHA1:       "4fa4f3344e2db600c11273028e63ba21976ede80",
+			Comment:    "edit #1",
+			Size:       1024,
+			Tags:       []string{"mw-undo"},
+			Minor:      true,
+		},
+		{
+			RevisionID: 1419073806,
+			ParentID:   0,
+			User:       "user2",
+			Timestamp:  time.Date(2021, time.May, 11, 16, 53, 18, 0, time.UTC),
+			SHA1:       "88a134dc3b112584e143003cadf0fdf3a4503dfe",
+			Comment:    "edit #2",
+			Size:       512,
+			Tags:       []string{},
+		},
+	}
+
+	if !reflect.DeepEqual(prov.Revisions, expected) {
+		t.Errorf("Revisions '%+v' are incorrect, expected: '%+v'", prov.Revisions, expected)
+	}
+
+	if len(prov.History) != 0 {
+		t.Errorf("History strings should not be returned by default, received: '%s'", prov.History)
+	}
+
+	client.RenderHistory = true
+	prov, _ = client.GetWikidataProvenance("Q12345", 2)
+
+	const expectedHistory = "2021-05-11T20:17:31Z (oldid: 1419131078): 'user1' edited: 'edit #1'"
+	if len(prov.History) != 2 || prov.History[0] != expectedHistory {
+		t.Errorf("History strings '%s' are incorrect, expected first: '%s'", prov.History, expectedHistory)
+	}
+}
+
+// TestParseEditSummary ensures that Wikibase edit summaries are parsed
+// into their parts.
+func TestParseEditSummary(t *testing.T) {
+	for _, test := range summaryTests {
+		summary := ParseEditSummary(test.comment)
+		if !reflect.DeepEqual(summary, test.expected) {
+			t.Errorf("Summary for '%s' is incorrect, \nreceived: '%+v', \nexpected: '%+v'",
+				test.comment,
+				summary,
+				test.expected,
+			)
+		}
+	}
+	summary := ParseEditSummary("/* wbsetclaim-update:2||1 */ [[Property:P4152]]: B297E169")
+	if !summary.References("P2748", "p4152") {
+		t.Errorf("Summary should reference P4152: %+v", summary)
+	}
+	if summary.References("P2748") {
+		t.Errorf("Summary should not reference P2748: %+v", summary)
+	}
+}
+
+// filterTest and filterTests provide table-driven testing of filtering
+// history by property below.
+type filterTest struct {
+	opts     Options
+	expected []int
+	requests int
+}
+
+var filterTests = []filterTest{
+	{Options{History: 1, Properties: []string{"P4152"}}, []int{1247209137}, 1},
+	{Options{History: 2, Properties: []string{"P4152"}}, []int{1247209137, 1247208427}, 2},
+	{Options{History: 5, Properties: []string{"p4152"}}, []int{1247209137, 1247208427}, 2},
+	{Options{History: FullHistory, Properties: []string{"P2748", "P4152"}}, []int{1247209137, 1247208427, 1120066880}, 2},
+	{Options{History: 2, Properties: []string{"P1163"}}, nil, 2},
+	{Options{History: 2}, []int{1874135847, 1732599165}, 1},
+}
+
+// TestGetProvenanceProperties ensures that history can be filtered to
+// those revisions that reference particular properties and that more
+// history is requested to find them.
+func TestGetProvenanceProperties(t *testing.T) {
+	for _, test := range filterTests {
+		requests := 0
+		testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			requests++
+			res.WriteHeader(200)
+			if req.URL.Query().Get("rvcontinue") == "" {
+				res.Write([]byte(testFilterPageOne))
+				return
+			}
+			res.Write([]byte(testFilterPageTwo))
+		}))
+		client := NewClient(defaultBaseURI)
+		client.APIURL = testServer.URL
+		prov, err := client.GetProvenance(context.Background(), "Q5381415", test.opts)
+		testServer.Close()
+		if err != nil {
+			t.Errorf("Unexpected error retrieving history: %s", err)
+		}
+		// Provenance continues to describe the latest revision of the
+		// entity no matter which revisions are returned in history.
+		if prov.Revision != 1874135847 {
+			t.Errorf("Provenance revision '%d' is incorrect, expected: '%d'", prov.Revision, 1874135847)
+		}
+		var revisions []int
+		for _, rev := range prov.Revisions {
+			revisions = append(revisions, rev.RevisionID)
+		}
+		if !reflect.DeepEqual(revisions, test.expected) {
+			t.Errorf("Revisions '%v' are incorrect, expected: '%v' (%+v)", revisions, test.expected, test.opts)
+		}
+		if requests != test.requests {
+			t.Errorf("Number of requests '%d' is incorrect, expected: '%d' (%+v)", requests, test.requests, test.opts)
+		}
+	}
+}
+
+// retryTest and retryTests provide table-driven testing of the retry
+// policy below. Responses are returned in order, the last repeating.
+type retryTest struct {
+	responses []int
+	header    string
+	body      string
+	attempts  int
+	requests  int
+	err       bool
+}
+
+var retryTests = []retryTest{
+	{[]int{200}, "", testJSON, 3, 1, false},
+	{[]int{503, 503, 200}, "", testJSON, 3, 3, false},
+	{[]int{429, 200}, "0", testJSON, 3, 2, false},
+	{[]int{502, 504, 500, 200}, "", testJSON, 3, 3, true},
+	{[]int{503}, "Wed, 21 Oct 2015 07:28:00 GMT", testJSON, 2, 2, true},
+	{[]int{400}, "", testJSON, 3, 1, true},
+	{[]int{404}, "", testJSON, 3, 1, true},
+	{[]int{200}, "0", testMaxLagJSON, 3, 3, true},
+	{[]int{503}, "", testJSON, 0, 1, true},
+}
+
+// TestRetry ensures that requests that fail for reasons that might be
+// temporary are retried, up to the maximum number of attempts
+// configured, and that other failures are not.
+func TestRetry(t *testing.T) {
+	for _, test := range retryTests {
+		requests := 0
+		testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			code := test.responses[len(test.responses)-1]
+			if requests < len(test.responses) {
+				code = test.responses[requests]
+			}
+			requests++
+			if test.header != "" {
+				res.Header().Set("Retry-After", test.header)
+			}
+			res.WriteHeader(code)
+			res.Write([]byte(test.body))
+		}))
+		client := NewClient(defaultBaseURI)
+		client.APIURL = testServer.URL
+		client.Retry = RetryPolicy{MaxAttempts: test.attempts, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+		prov, err := client.GetWikidataProvenance("Q12345", 5)
+		testServer.Close()
+		if (err != nil) != test.err {
+			t.Errorf("Unexpected error value '%v' for test: %+v", err, test)
+		}
+		if !test.err && prov.Title != "Q12345" {
+			t.Errorf("Expected provenance to be returned for test: %+v", test)
+		}
+		if requests != test.requests {
+			t.Errorf("Number of requests '%d' is incorrect, expected: '%d' (%+v)", requests, test.requests, test)
+		}
+	}
+}
+
+// TestMaxLag ensures that the maxlag parameter is sent to Wikibase
+// when it is configured.
+func TestMaxLag(t *testing.T) {
+	client := NewClient(defaultBaseURI)
+	req, _ := client.buildRequest(context.Background(), "Q12345", 1)
+	if req.URL.Query().Get("maxlag") != "" {
+		t.Errorf("maxlag should not be sent unless configured: %s", req.URL)
+	}
+	client.MaxLag = 5
+	req, _ = client.buildRequest(context.Background(), "Q12345", 1)
+	if req.URL.Query().Get("maxlag") != "5" {
+		t.Errorf("maxlag should be sent when configured: %s", req.URL)
+	}
+}
+
+// TestParseRetryAfter ensures that Retry-After is read in seconds, and
+// as an HTTP date.
+func TestParseRetryAfter(t *testing.T) {
+	header := http.Header{}
+	if delay := parseRetryAfter(header); delay != 0 {
+		t.Errorf("Expected no delay without a header, received: %s", delay)
+	}
+	header.Set("Retry-After", "120")
+	if delay := parseRetryAfter(header); delay != 120*time.Second {
+		t.Errorf("Expected a delay of 120 seconds, received: %s", delay)
+	}
+	header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if delay := parseRetryAfter(header); delay < 59*time.Minute || delay > time.Hour {
+		t.Errorf("Expected a delay of around an hour, received: %s", delay)
+	}
+	header.Set("Retry-After", "Wed, 21 Oct 2015 07:28:00 GMT")
+	if delay := parseRetryAfter(header); delay != 0 {
+		t.Errorf("Expected no delay for a date in the past, received: %s", delay)
+	}
+}
+
+// TestRetryDelay ensures that the delay between attempts backs off
+// exponentially within the limits configured.
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 8 * time.Second}
+	for attempt, max := range []time.Duration{1, 2, 4, 8, 8, 8} {
+		max = max * time.Second
+		delay := policy.delay(attempt+1, 0)
+		if delay < max/2 || delay > max {
+			t.Errorf("Delay '%s' for attempt '%d' should be between '%s' and '%s'", delay, attempt+1, max/2, max)
+		}
+	}
+	if delay := policy.delay(1, time.Minute); delay != time.Minute {
+		t.Errorf("Delay should honor Retry-After, received: '%s'", delay)
+	}
+}
+
+// errorTest and errorTests provide table-driven testing of the errors
+// returned to the caller below.
+type errorTest struct {
+	code     int
+	body     string
+	sentinel error
+	class    string
+	attempts int
+}
+
+var errorTests = []errorTest{
+	{200, testMissingJSON, ErrNotFound, ClassNotFound, 1},
+	{429, "slow down", ErrRateLimited, ClassRateLimited, 2},
+	{200, testMaxLagJSON, ErrRateLimited, ClassRateLimited, 2},
+	{503, "unavailable", ErrStatus, ClassStatus, 2},
+	{404, "not here", ErrStatus, ClassStatus, 1},
+	{200, testAPIErrorJSON, ErrAPI, ClassAPI, 1},
+	{200, "{\"not json", ErrDecode, ClassDecode, 1},
+}
+
+// TestErrors ensures that the errors returned to the caller can be
+// inspected using errors.Is and errors.As.
+func TestErrors(t *testing.T) {
+	for _, test := range errorTests {
+		testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.WriteHeader(test.code)
+			res.Write([]byte(test.body))
+		}))
+		client := NewClient(defaultBaseURI)
+		client.APIURL = testServer.URL
+		client.Retry = RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}
+		_, err := client.GetWikidataProvenance("Q999999999999", 5)
+		testServer.Close()
+		if !errors.Is(err, test.sentinel) {
+			t.Errorf("Error '%v' should match '%v'", err, test.sentinel)
+		}
+		if class := ErrorClass(err); class != test.class {
+			t.Errorf("Error class '%s' is incorrect, expected: '%s' (%v)", class, test.class, err)
+		}
+		if attempts := ErrorAttempts(err); attempts != test.attempts {
+			t.Errorf("Error attempts '%d' is incorrect, expected: '%d' (%v)", attempts, test.attempts, err)
+		}
+	}
+
+	var statusErr *StatusError
+	err := fmt.Errorf("wrapped: %w", &RetryError{Attempts: 3, Err: &StatusError{Code: 503, Body: "unavailable"}})
+	if !errors.As(err, &statusErr) || statusErr.Code != 503 || statusErr.Body != "unavailable" {
+		t.Errorf("Expected StatusError to be available to the caller: %v", err)
+	}
+
+	var apiErr *APIError
+	err = &RateLimitError{Err: &APIError{Code: "maxlag", Info: "lagged"}}
+	if !errors.As(err, &apiErr) || apiErr.Code != "maxlag" {
+		t.Errorf("Expected APIError to be available to the caller: %v", err)
+	}
+}
+
+// TestProvenanceErrorJSON ensures that an error attached to provenance
+// is included when it is output as JSON.
+func TestProvenanceErrorJSON(t *testing.T) {
+	prov := Provenance{Title: "Q12345", Error: &NotFoundError{Title: "Q12345"}}
+	expected := `{"Title":"Q12345","Error":{"Class":"not_found","Message":"entity not found in Wikibase: 'Q12345'"}}`
+	data, err := json.Marshal(prov)
+	if err != nil {
+		t.Errorf("Unexpected error marshaling provenance: %s", err)
+	}
+	if string(data) != expected {
+		t.Errorf("Provenance JSON is incorrect, \nreceived: '%s', \nexpected: '%s'", data, expected)
+	}
+	data, _ = json.Marshal(Provenance{Title: "Q12345"})
+	if strings.Contains(string(data), "Error") {
+		t.Errorf("Provenance JSON should not include an error unless there is one: '%s'", data)
+	}
+}
+
+// newRedirectTestServer returns a server that describes Q1111 as a
+// redirect to Q2222 and Q999999999999 as missing, and, if deleted is
+// true, deleted.
+func newRedirectTestServer(deleted bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		switch {
+		case query.Get("list") == "logevents" && deleted:
+			fmt.Fprintln(res, testDeletedLogJSON)
+		case query.Get("list") == "logevents":
+			fmt.Fprintln(res, testEmptyLogJSON)
+		case query.Get("redirects") != "":
+			fmt.Fprintln(res, testResolvedRedirectJSON)
+		case query.Get("titles") == "item:Q1111":
+			fmt.Fprintln(res, testRedirectJSON)
+		case query.Get("titles") == "item:Q2222":
+			fmt.Fprintln(res, testRedirectTargetJSON)
+		default:
+			fmt.Fprintln(res, testMissingJSON)
+		}
+	}))
+}
+
+// TestRedirects ensures that redirected entities are flagged and that
+// redirects are followed when the caller asks.
+func TestRedirects(t *testing.T) {
+	testServer := newRedirectTestServer(false)
+	defer testServer.Close()
+	client := NewClient(testServer.URL)
+	client.APIURL = testServer.URL
+
+	prov, err := client.GetProvenance(context.Background(), "Q1111", Options{History: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving redirect: %s", err)
+	}
+	if prov.Title != "Q1111" || prov.RedirectedTo != "Q2222" || prov.RedirectedFrom != "" {
+		t.Errorf("Redirect not flagged correctly: title: '%s', to: '%s', from: '%s'",
+			prov.Title, prov.RedirectedTo, prov.RedirectedFrom)
+	}
+
+	prov, err = client.GetProvenance(context.Background(), "Q1111", Options{History: 1, FollowRedirects: true})
+	if err != nil {
+		t.Fatalf("Unexpected error following redirect: %s", err)
+	}
+	if prov.Title != "Q2222" || prov.RedirectedFrom != "Q1111" || prov.RedirectedTo != "" {
+		t.Errorf("Redirect not followed correctly: title: '%s', to: '%s', from: '%s'",
+			prov.Title, prov.RedirectedTo, prov.RedirectedFrom)
+	}
+	if prov.Revision != 301 {
+		t.Errorf("Expected provenance of redirect target, received revision: '%d'", prov.Revision)
+	}
+}
+
+// TestMissingAndDeleted ensures that entities that don't exist are
+// flagged as missing and, where the deletion log tells us so, deleted.
+func TestMissingAndDeleted(t *testing.T) {
+	for _, deleted := range []bool{false, true} {
+		testServer := newRedirectTestServer(deleted)
+		client := NewClient(testServer.URL)
+		client.APIURL = testServer.URL
+		prov, err := client.GetProvenance(context.Background(), "Q999999999999", Options{History: 1})
+		testServer.Close()
+		var notFound *NotFoundError
+		if !errors.As(err, &notFound) || !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Expected NotFoundError, received: %v", err)
+		}
+		if notFound.Deleted != deleted {
+			t.Errorf("NotFoundError deleted flag incorrect, received: '%t', expected: '%t'", notFound.Deleted, deleted)
+		}
+		if !prov.Missing || prov.Deleted != deleted || prov.Title != "Q999999999999" {
+			t.Errorf("Missing entity not flagged correctly: title: '%s', missing: '%t', deleted: '%t'",
+				prov.Title, prov.Missing, prov.Deleted)
+		}
+	}
+}
+
+// TestCreation ensures that the creation of an entity is added to its
+// provenance, requesting its first revision only when it isn't already
+// part of the history retrieved.
+func TestCreation(t *testing.T) {
+	var creationRequests int
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		switch {
+		case query.Get("rvdir") == "newer":
+			creationRequests++
+			fmt.Fprintln(res, testPageThree)
+		case query.Get("titles") == "item:Q1":
+			fmt.Fprintln(res, testRevisionJSON)
+		default:
+			fmt.Fprintln(res, testPageOne)
+		}
+	}))
+	defer testServer.Close()
+	client := NewClient(testServer.URL)
+	client.APIURL = testServer.URL
+
+	prov, err := client.GetProvenance(context.Background(), "Q12345", Options{History: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving provenance: %s", err)
+	}
+	if prov.Created != "" || prov.Creator != "" || creationRequests != 0 {
+		t.Errorf("Creation should only be retrieved when it is requested")
+	}
+
+	prov, err = client.GetProvenance(context.Background(), "Q12345", Options{History: 1, Creation: true})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving creation: %s", err)
+	}
+	if prov.Created != "2021-03-31T10:27:19Z" || prov.Creator != "user5" {
+		t.Errorf("Creation incorrect, received: '%s' '%s'", prov.Created, prov.Creator)
+	}
+	if len(prov.Revisions) != 1 || prov.Revisions[0].User != "user1" {
+		t.Errorf("Creation should not change the revisions returned: %v", prov.Revisions)
+	}
+	if creationRequests != 1 {
+		t.Errorf("Expected one request for the first revision, received: '%d'", creationRequests)
+	}
+
+	prov, err = client.GetProvenance(context.Background(), "Q1", Options{History: 2, Creation: true})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving creation: %s", err)
+	}
+	if prov.Created != "2021-05-11T16:53:18Z" || prov.Creator != "user2" {
+		t.Errorf("Creation incorrect, received: '%s' '%s'", prov.Created, prov.Creator)
+	}
+	if creationRequests != 1 {
+		t.Errorf("First revision should not be requested when it has been retrieved")
+	}
+}
+
+// revisionPropertiesTest describes the value of rvprop expected for
+// the revision properties configured for a client and request.
+type revisionPropertiesTest struct {
+	client   []string
+	opts     []string
+	expected string
+	err      bool
+}
+
+var revisionPropertiesTests = []revisionPropertiesTest{
+	{nil, nil, "ids|user|comment|timestamp|sha1", false},
+	{[]string{"user", "tags"}, nil, "ids|timestamp|user|tags", false},
+	{[]string{"user", "tags"}, []string{"Size", " userid", "size"}, "ids|timestamp|size|userid", false},
+	{nil, []string{"ids", "flags", "contentmodel"}, "ids|timestamp|flags|contentmodel", false},
+	{nil, []string{"content"}, "", true},
+	{[]string{"parsedcomment"}, nil, "", true},
+}
+
+// TestRevisionProperties ensures that the revision properties requested
+// from Wikibase can be configured and that each is returned in the
+// revisions.
+func TestRevisionProperties(t *testing.T) {
+	var rvprop string
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		rvprop = req.URL.Query().Get("rvprop")
+		fmt.Fprintln(res, testRevisionPropertiesJSON)
+	}))
+	defer testServer.Close()
+
+	for _, test := range revisionPropertiesTests {
+		rvprop = ""
+		client := NewClient(testServer.URL)
+		client.APIURL = testServer.URL
+		client.RevisionProperties = test.client
+		_, err := client.GetProvenance(context.Background(), "Q12345", Options{History: 1, RevisionProperties: test.opts})
+		if test.err {
+			if !errors.Is(err, ErrRevisionProperty) {
+				t.Errorf("Expected ErrRevisionProperty for '%v' '%v', received: %v", test.client, test.opts, err)
+			}
+			if rvprop != "" {
+				t.Errorf("Request should not be made with unknown revision properties: '%s'", rvprop)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error requesting revision properties: %s", err)
+		}
+		if rvprop != test.expected {
+			t.Errorf("Revision properties requested incorrectly, received: '%s', expected: '%s'", rvprop, test.expected)
+		}
+	}
+
+	client := NewClient(testServer.URL)
+	client.APIURL = testServer.URL
+	prov, err := client.GetProvenance(context.Background(), "Q12345", Options{History: 1, RevisionProperties: KnownRevisionProperties()})
+	if err != nil {
+		t.Fatalf("Unexpected error requesting revision properties: %s", err)
+	}
+	expected := Revision{
+		RevisionID:   1419131078,
+		ParentID:     1419073806,
+		User:         "user1",
+		Timestamp:    time.Date(2021, time.May, 11, 20, 17, 31, 0, time.UTC),
+		SHA1:         "4fa4f3344e2db600c11273028e63ba21976ede80",
+		Comment:      "/* undo:0||1419073806|user2 */",
+		Size:         1024,
+		Tags:         []string{"mw-undo", "OAuth CID: 1776"},
+		Minor:        true,
+		UserID:       4321,
+		ContentModel: "wikibase-item",
+	}
+	if len(prov.Revisions) != 1 || !reflect.DeepEqual(prov.Revisions[0], expected) {
+		t.Errorf("Revision incorrect, \nreceived: '%+v', \nexpected: '%+v'", prov.Revisions, expected)
+	}
+	data, _ := json.Marshal(prov.Revisions[0])
+	for _, key := range []string{`"UserID":4321`, `"ContentModel":"wikibase-item"`, `"Tags":["mw-undo","OAuth CID: 1776"]`} {
+		if !strings.Contains(string(data), key) {
+			t.Errorf("Revision JSON missing '%s': %s", key, data)
+		}
+	}
+}
+
+// TestGetProvenanceBatch ensures that the latest revisions of entities
+// are requested in batches and that entities the batch can't describe
+// are requested individually.
+func TestGetProvenanceBatch(t *testing.T) {
+	var batchRequests, singleRequests int
+	redirects := newRedirectTestServer(false)
+	defer redirects.Close()
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if strings.Contains(query.Get("titles"), "|") {
+			batchRequests++
+			if query.Get("rvlimit") != "" {
+				t.Errorf("rvlimit cannot be sent with more than one title: '%s'", query.Get("rvlimit"))
+			}
+			fmt.Fprintln(res, testBatchJSON)
+			return
+		}
+		singleRequests++
+		// Individual requests are answered as they would be for a
+		// single entity.
+		redirects.Config.Handler.ServeHTTP(res, req)
+	}))
+	defer testServer.Close()
+	client := NewClient(testServer.URL)
+	client.APIURL = testServer.URL
+
+	ids := []string{"Q12345", "Q1111", "Q999999999999", "Q2222"}
+	provs := client.GetProvenanceBatch(context.Background(), ids, Options{History: 1})
+	if len(provs) != len(ids) {
+		t.Fatalf("Expected '%d' results, received: '%d'", len(ids), len(provs))
+	}
+	for idx, id := range ids {
+		if provs[idx].Title != id {
+			t.Errorf("Results out of order, received: '%s', expected: '%s'", provs[idx].Title, id)
+		}
+	}
+	if provs[0].Revision != 1419131078 || provs[0].Error != nil || len(provs[0].Revisions) != 1 {
+		t.Errorf("Batched provenance incorrect: %+v", provs[0])
+	}
+	if provs[1].RedirectedTo != "Q2222" {
+		t.Errorf("Redirect should be requested individually: %+v", provs[1])
+	}
+	if !errors.Is(provs[2].Error, ErrNotFound) || !provs[2].Missing {
+		t.Errorf("Missing entity should be requested individually: %+v", provs[2])
+	}
+	if provs[3].Revision != 301 {
+		t.Errorf("Batched provenance incorrect: %+v", provs[3])
+	}
+	if batchRequests != 1 {
+		t.Errorf("Expected one batch request, received: '%d'", batchRequests)
+	}
+
+	// Deeper history can't be batched.
+	batchRequests = 0
+	provs = client.GetProvenanceBatch(context.Background(), []string{"Q12345", "Q2222"}, Options{History: 2})
+	if batchRequests != 0 || len(provs) != 2 {
+		t.Errorf("History longer than one revision should not be batched, batch requests: '%d'", batchRequests)
+	}
+}
+
+// TestTransportConfig ensures that transports can be configured with a
+// proxy and certificate authorities and that clients share a transport
+// by default.
+func TestTransportConfig(t *testing.T) {
+	if NewClient(defaultBaseURI).HTTPClient != NewClient("http://example.com").HTTPClient {
+		t.Errorf("Clients created with NewClient should share an http.Client")
+	}
+
+	config := DefaultTransportConfig
+	config.Proxy = "not a proxy"
+	if _, err := config.NewTransport(); err == nil {
+		t.Errorf("Expected an error for an invalid proxy URL")
+	}
+
+	dir := t.TempDir()
+	config = DefaultTransportConfig
+	config.CAFile = filepath.Join(dir, "missing.pem")
+	if _, err := config.NewTransport(); err == nil {
+		t.Errorf("Expected an error for a missing CA file")
+	}
+	config.CAFile = filepath.Join(dir, "empty.pem")
+	os.WriteFile(config.CAFile, []byte("no certificates here"), 0o600)
+	if _, err := config.NewTransport(); err == nil {
+		t.Errorf("Expected an error for a CA file without certificates")
+	}
+
+	config = DefaultTransportConfig
+	config.DisableHTTP2 = true
+	transport, err := config.NewTransport()
+	if err != nil || transport.TLSNextProto == nil || transport.ForceAttemptHTTP2 {
+		t.Errorf("Expected HTTP/2 to be disabled: %v", err)
+	}
+
+	// A server with a certificate that isn't trusted by the system is
+	// trusted when its certificate authority is given.
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(res, testJSON)
+	}))
+	defer tlsServer.Close()
+	client := NewClient(tlsServer.URL)
+	client.APIURL = tlsServer.URL
+	client.Retry.MaxAttempts = 1
+	if _, err := client.GetProvenance(context.Background(), "Q12345", Options{History: 1}); err == nil {
+		t.Errorf("Expected an error connecting to a server with an untrusted certificate")
+	}
+	config = DefaultTransportConfig
+	config.CAFile = filepath.Join(dir, "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw})
+	os.WriteFile(config.CAFile, certificate, 0o600)
+	client.HTTPClient, err = config.NewHTTPClient()
+	if err != nil {
+		t.Fatalf("Unexpected error creating client: %s", err)
+	}
+	if _, err := client.GetProvenance(context.Background(), "Q12345", Options{History: 1}); err != nil {
+		t.Errorf("Unexpected error connecting with trusted certificate authority: %s", err)
+	}
+
+	// Requests are sent through the proxy when one is configured.
+	var proxied string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		proxied = req.URL.String()
+		fmt.Fprintln(res, testJSON)
+	}))
+	defer proxyServer.Close()
+	config = DefaultTransportConfig
+	config.Proxy = proxyServer.URL
+	client = NewClient("http://wikibase.example.com/")
+	client.HTTPClient, err = config.NewHTTPClient()
+	if err != nil {
+		t.Fatalf("Unexpected error creating client: %s", err)
+	}
+	if _, err := client.GetProvenance(context.Background(), "Q12345", Options{History: 1}); err != nil {
+		t.Errorf("Unexpected error connecting through proxy: %s", err)
+	}
+	if !strings.HasPrefix(proxied, "http://wikibase.example.com/w/api.php?") {
+		t.Errorf("Request not sent through proxy, received: '%s'", proxied)
+	}
+}
+
+// TestRateLimiter ensures that requests are limited to the configured
+// rate and burst across go routines.
+func TestRateLimiter(t *testing.T) {
+	var requests int
+	var mutex sync.Mutex
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		fmt.Fprintln(res, testJSON)
+	}))
+	defer testServer.Close()
+
+	// Five requests are allowed at once, the remaining five are spaced
+	// at 50 requests per second, i.e. 20ms apart.
+	client := NewClient(testServer.URL)
+	client.APIURL = testServer.URL
+	client.RateLimiter = NewRateLimiter(50, 5)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for idx := 0; idx < 10; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetProvenance(context.Background(), "Q12345", Options{History: 1}); err != nil {
+				t.Errorf("Unexpected error from rate limited client: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Requests were not rate limited, 10 requests took: '%s'", elapsed)
+	}
+	if requests != 10 {
+		t.Errorf("Expected '%d' requests, received: '%d'", 10, requests)
+	}
+
+	// Waiting returns early when the context is done.
+	limiter := NewRateLimiter(0.1, 1)
+	limiter.Wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context error waiting on rate limiter, received: %v", err)
+	}
+
+	// Nil and unlimited limiters never block.
+	var unlimited *RateLimiter
+	for _, limiter := range []*RateLimiter{unlimited, NewRateLimiter(0, 1)} {
+		for idx := 0; idx < 100; idx++ {
+			if err := limiter.Wait(context.Background()); err != nil {
+				t.Errorf("Unexpected error from unlimited rate limiter: %s", err)
+			}
+		}
+	}
+}
+
+// TestCache ensures that cached provenance is returned while entities
+// are unchanged and requested again once they have been edited.
+func TestCache(t *testing.T) {
+	lastRevID := 301
+	var infoRequests, revisionRequests int
+	redirects := newRedirectTestServer(false)
+	defer redirects.Close()
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("prop") == "info" {
+			infoRequests++
+			fmt.Fprintf(res, testCacheInfoJSON, lastRevID)
+			return
+		}
+		revisionRequests++
+		if strings.Contains(query.Get("titles"), "|") {
+			fmt.Fprintln(res, testBatchJSON)
+			return
+		}
+		redirects.Config.Handler.ServeHTTP(res, req)
+	}))
+	defer testServer.Close()
+
+	cache, err := NewFileCache(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(testServer.URL)
+	client.APIURL = testServer.URL
+	client.Cache = cache
+
+	ids := []string{"Q12345", "Q2222"}
+	opts := Options{History: 1}
+	first := client.GetProvenanceBatch(context.Background(), ids, opts)
+	// The latest revision of entities not yet cached is taken from the
+	// batch rather than requested separately.
+	if infoRequests != 0 || revisionRequests != 1 {
+		t.Errorf("Expected a single revision request, received info and revision requests: '%d', '%d'", infoRequests, revisionRequests)
+	}
+
+	infoRequests, revisionRequests = 0, 0
+	second := client.GetProvenanceBatch(context.Background(), ids, opts)
+	if infoRequests != 1 || revisionRequests != 0 {
+		t.Errorf("Expected provenance from the cache, info and revision requests: '%d', '%d'", infoRequests, revisionRequests)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Cached provenance differs, received: %+v, expected: %+v", second, first)
+	}
+	prov, err := client.GetProvenance(context.Background(), "Q12345", opts)
+	if err != nil || revisionRequests != 0 || !reflect.DeepEqual(prov, first[0]) {
+		t.Errorf("Expected provenance from the cache: %+v (%v), revision requests: '%d'", prov, err, revisionRequests)
+	}
+
+	// Rendered history isn't cached but is rendered as the client asks.
+	client.RenderHistory = true
+	prov, _ = client.GetProvenance(context.Background(), "Q12345", opts)
+	if len(prov.History) != 1 || revisionRequests != 0 {
+		t.Errorf("Expected history rendered from the cache, received: %v", prov.History)
+	}
+	client.RenderHistory = false
+
+	// Provenance requested with different options is cached separately.
+	client.GetProvenanceBatch(context.Background(), ids, Options{History: 1, FollowRedirects: true})
+	if revisionRequests == 0 {
+		t.Errorf("Provenance requested with different options should not be returned from the cache")
+	}
+
+	// Clients of the same Wikibase that build permalinks and entity
+	// URIs differently don't share provenance.
+	other := NewClient(testServer.URL)
+	other.APIURL = testServer.URL
+	other.EntityURI = "https://wikibase.example.com/entity/"
+	other.Cache = cache
+	revisionRequests = 0
+	prov, err = other.GetProvenance(context.Background(), "Q2222", opts)
+	if err != nil || revisionRequests != 1 || prov.Entity != "https://wikibase.example.com/entity/Q2222" {
+		t.Errorf("Expected provenance for a differently configured client to be requested: %+v (%v)", prov, err)
+	}
+
+	// Once Q2222 is edited its provenance is requested again.
+	lastRevID++
+	revisionRequests = 0
+	client.GetProvenanceBatch(context.Background(), ids, opts)
+	if revisionRequests != 1 {
+		t.Errorf("Expected the edited entity to be requested again, revision requests: '%d'", revisionRequests)
+	}
+}
+
+// TestMemoryCache ensures that the in-memory cache evicts the least
+// recently used entries and expires entries after their time to live.
+func TestMemoryCache(t *testing.T) {
+	cache := NewMemoryCache(2, 0)
+	cache.Put("a", CacheEntry{LastRevID: 1})
+	cache.Put("b", CacheEntry{LastRevID: 2})
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatalf("Expected 'a' to be cached")
+	}
+	// 'b' is now the least recently used.
+	cache.Put("c", CacheEntry{LastRevID: 3})
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("Expected 'b' to be evicted")
+	}
+	if entry, ok := cache.Get("a"); !ok || entry.LastRevID != 1 {
+		t.Errorf("Expected 'a' to be kept, received: %+v", entry)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Expected the cache to hold '2' entries, received: '%d'", cache.Len())
+	}
+
+	// Callers cannot modify what is held.
+	cache.Put("d", CacheEntry{Provenance: Provenance{Revisions: []Revision{{RevisionID: 1}}}})
+	entry, _ := cache.Get("d")
+	entry.Provenance.Revisions[0].RevisionID = 2
+	if entry, _ := cache.Get("d"); entry.Provenance.Revisions[0].RevisionID != 1 {
+		t.Errorf("Cached revisions were modified by the caller")
+	}
+
+	cache = NewMemoryCache(0, time.Millisecond)
+	cache.Put("a", CacheEntry{LastRevID: 1})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.Get("a"); ok || cache.Len() != 0 {
+		t.Errorf("Expected 'a' to expire")
+	}
+}
+
+// TestCacheSharedRequests ensures that concurrent requests for the same
+// provenance result in a single request to Wikibase, and that provenance
+// held by a memory cache is returned without checking it until it
+// expires.
+func TestCacheSharedRequests(t *testing.T) {
+	const callers = 8
+	var mutex sync.Mutex
+	var requests int
+	started := make(chan struct{}, callers)
+	var release chan struct{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		// Hold the response until every caller has started so that
+		// they ask for the provenance while it is being requested.
+		<-release
+		if strings.Contains(req.URL.Query().Get("titles"), "|") {
+			fmt.Fprintln(res, testBatchJSON)
+			return
+		}
+		fmt.Fprintln(res, testRedirectTargetJSON)
+	}))
+	defer testServer.Close()
+
+	client := NewClient(testServer.URL)
+	client.APIURL = testServer.URL
+	client.Cache = NewMemoryCache(10, time.Minute)
+
+	tests := []struct {
+		ids      []string
+		requests int
+	}{
+		{[]string{"Q2222"}, 1},
+		{[]string{"Q12345", "Q2222", "Q12345"}, 1},
+	}
+	for _, test := range tests {
+		requests = 0
+		release = make(chan struct{})
+		go func() {
+			for caller := 0; caller < callers; caller++ {
+				<-started
+			}
+			time.Sleep(10 * time.Millisecond)
+			close(release)
+		}()
+		results := make([][]Provenance, callers)
+		var wg sync.WaitGroup
+		wg.Add(callers)
+		for caller := 0; caller < callers; caller++ {
+			go func(caller int) {
+				defer wg.Done()
+				started <- struct{}{}
+				results[caller] = client.GetProvenanceBatch(context.Background(), test.ids, Options{History: 1})
+			}(caller)
+		}
+		wg.Wait()
+		if requests != test.requests {
+			t.Errorf("Expected '%d' requests for the provenance of %v, received: '%d'", test.requests, test.ids, requests)
+		}
+		for caller := 0; caller < callers; caller++ {
+			if !reflect.DeepEqual(results[0], results[caller]) || results[caller][0].Revision == 0 {
+				t.Errorf("Shared provenance differs, received: %+v, expected: %+v", results[caller], results[0])
+			}
+		}
+	}
+
+	// Provenance held by the memory cache is returned without any
+	// request being made.
+	requests = 0
+	if _, err := client.GetProvenance(context.Background(), "Q2222", Options{History: 1}); err != nil || requests != 0 {
+		t.Errorf("Expected provenance from the memory cache without a request, received: '%d' requests (%v)", requests, err)
+	}
+}
+
+// TestRefreshProvenance ensures that only revisions newer than those
+// stored are requested and that they are added to the stored history.
+func TestRefreshProvenance(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if endID := req.URL.Query().Get("rvendid"); endID != "1419073806" {
+			t.Errorf("Expected revisions to be requested back to the stored revision, received: '%s'", endID)
+		}
+		fmt.Fprintln(res, testRefreshJSON)
+	}))
+	defer testServer.Close()
+	client := NewClient(testServer.URL)
+	client.APIURL = testServer.URL
+
+	stored := Provenance{
+		Title:    "Q12345",
+		Revision: 1419073806,
+		Creator:  "user1",
+		Revisions: []Revision{
+			{RevisionID: 1419073806, User: "user1", Comment: "edit #1"},
+		},
+	}
+	prov, added, err := client.RefreshProvenance(context.Background(), stored, Options{})
+	if err != nil {
+		t.Fatalf("Unexpected error refreshing provenance: %s", err)
+	}
+	if added != 2 || len(prov.Revisions) != 3 {
+		t.Fatalf("Expected '2' revisions to be added to the history, added: '%d', history: %+v", added, prov.Revisions)
+	}
+	if prov.Revision != 1419131080 || prov.Modified != "2021-05-12T09:00:00Z" || prov.Revisions[0].User != "user3" {
+		t.Errorf("Refreshed provenance doesn't describe the latest revision: %+v", prov)
+	}
+	if prov.Creator != "user1" || len(stored.Revisions) != 1 {
+		t.Errorf("Stored provenance should be kept and not modified: %+v, %+v", prov, stored)
+	}
+
+	// Only revisions matching the options are added but the latest
+	// revision is still recorded.
+	prov, added, err = client.RefreshProvenance(context.Background(), stored, Options{Properties: []string{"P2748"}, History: 1})
+	if err != nil || added != 1 || len(prov.Revisions) != 1 || prov.Revisions[0].RevisionID != 1419131080 {
+		t.Errorf("Expected a single matching revision to be added: %+v (%d)", prov.Revisions, added)
+	}
+
+	// Nothing is added when the stored provenance is up to date.
+	stored = prov
+	testServer.Config.Handler = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(res, testJSON)
+	})
+	stored.Revision = 1419131078
+	if _, added, err := client.RefreshProvenance(context.Background(), stored, Options{}); err != nil || added != 0 {
+		t.Errorf("Expected nothing to be added, added: '%d' (%v)", added, err)
+	}
+}
+
+// TestAsOf ensures that provenance can be requested as it was at a
+// point in time.
+func TestAsOf(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("rvdir") != "older" {
+			t.Errorf("Expected revisions to be listed backwards, received: '%s'", query.Get("rvdir"))
+		}
+		switch query.Get("rvstart") {
+		case "2021-05-11T18:00:00Z":
+			fmt.Fprintln(res, testAsOfJSON)
+		default:
+			fmt.Fprintln(res, testBeforeCreationJSON)
+		}
+	}))
+	defer testServer.Close()
+	client := NewClient(testServer.URL)
+	client.APIURL = testServer.URL
+
+	// Times are sent to the API in UTC.
+	asOf := time.Date(2021, 5, 11, 19, 0, 0, 0, time.FixedZone("BST", 60*60))
+	opts := Options{History: 5, AsOf: asOf}
+	if opts.Batchable() {
+		t.Errorf("Provenance as of a point in time cannot be batched")
+	}
+	prov, err := client.GetProvenance(context.Background(), "Q12345", opts)
+	if err != nil {
+		t.Fatalf("Unexpected error requesting provenance as of: %s", err)
+	}
+	if prov.Revision != 1419073806 || prov.AsOf != "2021-05-11T18:00:00Z" || len(prov.Revisions) != 1 {
+		t.Errorf("Expected provenance as of the revision in force, received: %+v", prov)
+	}
+	if !strings.Contains(prov.Permalink, "oldid=1419073806") {
+		t.Errorf("Expected a permalink to the revision in force, received: '%s'", prov.Permalink)
+	}
+
+	opts.AsOf = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = client.GetProvenance(context.Background(), "Q12345", opts)
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) || !notFound.AsOf.Equal(opts.AsOf) {
+		t.Errorf("Expected an entity created later not to be found, received: %v", err)
+	}
+	if _, _, err := client.RefreshProvenance(context.Background(), prov, opts); err == nil {
+		t.Errorf("Expected an error refreshing provenance as of a point in time")
+	}
+}
+
+// TestRevisionFilters ensures that revisions can be filtered by when
+// they were made and by whom.
+func TestRevisionFilters(t *testing.T) {
+	var continued, userRequests int
+	var start string
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("list") == "users" {
+			userRequests++
+			if users := query.Get("ususers"); strings.Contains(users, "192.0.2.1") {
+				t.Errorf("Anonymous editors should not be looked up: '%s'", users)
+			}
+			fmt.Fprintln(res, testUserGroupsJSON)
+			return
+		}
+		if query.Get("rvcontinue") != "" {
+			// The rest of the history was made before 2021.
+			continued++
+			fmt.Fprintln(res, testMissingJSON)
+			return
+		}
+		if query.Get("rvstart") != "" {
+			start = query.Get("rvstart")
+			fmt.Fprintln(res, testUntilJSON)
+			return
+		}
+		fmt.Fprintln(res, testFilterJSON)
+	}))
+	defer testServer.Close()
+	client := NewClient(testServer.URL)
+	client.APIURL = testServer.URL
+
+	date := func(month time.Month) time.Time {
+		return time.Date(2021, month, 1, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		opts     Options
+		expected []int
+	}{
+		{Options{Since: date(time.April)}, []int{105, 104, 103}},
+		{Options{Since: date(time.March), Until: date(time.April)}, []int{103, 102}},
+		{Options{Users: []string{"winston_Smith"}}, []int{103, 101}},
+		{Options{ExcludeUsers: []string{"Winston Smith", "Emmanuel Goldstein"}}, []int{104, 102}},
+		{Options{ExcludeAnonymous: true}, []int{105, 103, 102, 101}},
+		{Options{ExcludeBots: true, ExcludeAnonymous: true}, []int{105, 103, 101}},
+	}
+	for _, test := range tests {
+		continued, start = 0, ""
+		test.opts.History = FullHistory
+		prov, err := client.GetProvenance(context.Background(), "Q12345", test.opts)
+		if err != nil {
+			t.Fatalf("Unexpected error filtering revisions: %s", err)
+		}
+		var received []int
+		for _, rev := range prov.Revisions {
+			received = append(received, rev.RevisionID)
+		}
+		if !reflect.DeepEqual(received, test.expected) {
+			t.Errorf("Revisions filtered incorrectly with %+v, received: %v, expected: %v", test.opts, received, test.expected)
+		}
+		if prov.Revision != 105 {
+			t.Errorf("Provenance should describe the latest revision, received: '%d'", prov.Revision)
+		}
+		// The first page reaches back before Since so the next isn't
+		// needed.
+		if !test.opts.Since.IsZero() && continued != 0 {
+			t.Errorf("Expected history before '%s' not to be requested, requests: '%d'", test.opts.Since, continued)
+		}
+		// History after Until isn't requested.
+		if !test.opts.Until.IsZero() && start != "2021-04-01T00:00:00Z" {
+			t.Errorf("Expected history to be listed back from '%s', received: '%s'", test.opts.Until, start)
+		}
+	}
+	if !anonymous(Revision{User: "192.0.2.1"}) {
+		t.Errorf("IP addresses should be treated as anonymous")
+	}
+
+	// Users are only looked up once.
+	client.GetProvenance(context.Background(), "Q12345", Options{History: FullHistory, ExcludeBots: true})
+	if userRequests != 1 {
+		t.Errorf("Expected users to be looked up once, received: '%d'", userRequests)
+	}
+}
+
+// TestSnapshot ensures that the JSON of an entity at the revision its
+// provenance describes can be attached to it.
+func TestSnapshot(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		switch {
+		case strings.HasSuffix(req.URL.Path, "index.php") && query.Get("revision") == "1419131078":
+			if query.Get("title") != "Special:EntityData/Q12345.json" {
+				t.Errorf("Unexpected snapshot requested: '%s'", query.Get("title"))
+			}
+			fmt.Fprintln(res, testSnapshotJSON)
+		case strings.HasSuffix(req.URL.Path, "index.php"):
+			res.WriteHeader(http.StatusNotFound)
+		default:
+			fmt.Fprintln(res, testJSON)
+		}
+	}))
+	defer testServer.Close()
+	client := NewClient(testServer.URL)
+	client.Retry.MaxAttempts = 1
+
+	opts := Options{History: 1, Snapshot: true}
+	if opts.Batchable() {
+		t.Errorf("Snapshots cannot be batched")
+	}
+	prov, err := client.GetProvenance(context.Background(), "Q12345", opts)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving snapshot: %s", err)
+	}
+	var snapshot struct {
+		Entities map[string]struct {
+			LastRevID int `json:"lastrevid"`
+		} `json:"entities"`
+	}
+	if err := json.Unmarshal(prov.Snapshot, &snapshot); err != nil {
+		t.Fatalf("Snapshot isn't JSON: %s", err)
+	}
+	if snapshot.Entities["Q12345"].LastRevID != prov.Revision {
+		t.Errorf("Snapshot isn't of the provenance revision: %s", prov.Snapshot)
+	}
+	if !strings.Contains(prov.String(), "Count von Count") {
+		t.Errorf("Snapshot should be output with provenance")
+	}
+
+	if _, err := client.GetSnapshot(context.Background(), "Q12345", 1); !errors.Is(err, ErrStatus) {
+		t.Errorf("Expected a status error for a revision without a snapshot, received: %v", err)
+	}
+
+	// Properties are requested by their ID rather than their title.
+	testServer.Config.Handler = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if title := req.URL.Query().Get("title"); title != "Special:EntityData/P31.json" {
+			t.Errorf("Unexpected snapshot requested: '%s'", title)
+		}
+		fmt.Fprintln(res, `{"entities": {"P31": {"type": "property", "id": "P31"}}}`)
+	})
+	if _, err := client.GetSnapshot(context.Background(), "Property:P31", 1); err != nil {
+		t.Errorf("Unexpected error retrieving the snapshot of a property: %s", err)
+	}
+
+	// Provenance is kept when its snapshot cannot be retrieved.
+	testServer.Config.Handler = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "index.php") {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintln(res, testJSON)
+	})
+	prov, err = client.GetProvenance(context.Background(), "Q12345", opts)
+	if !errors.Is(err, ErrStatus) || !errors.Is(prov.Error, ErrStatus) {
+		t.Errorf("Expected the snapshot error to be recorded, received: %v, %v", err, prov.Error)
+	}
+	if prov.Revision != 1419131078 || prov.Snapshot != nil {
+		t.Errorf("Expected provenance without a snapshot, received: %+v", prov)
+	}
+}
+
+// TestVerifyProvenance ensures that the content of revisions is
+// verified against their SHA1 and against that recorded in stored
+// provenance.
+func TestVerifyProvenance(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("revids") != "101|102|103|104|105" || query.Get("rvslots") != "main" {
+			t.Errorf("Unexpected request for content: '%s'", req.URL.RawQuery)
+		}
+		fmt.Fprintln(res, testContentJSON)
+	}))
+	defer testServer.Close()
+	client := NewClient(testServer.URL)
+	client.APIURL = testServer.URL
+
+	const sha1 = "eee69f18962e4cab7be819171050a8f9940dca21"
+	stored := Provenance{
+		Title: "Q12345",
+		Revisions: []Revision{
+			{RevisionID: 101, SHA1: sha1},
+			{RevisionID: 102, SHA1: "1111111111111111111111111111111111111111"},
+			{RevisionID: 103},
+			{RevisionID: 104},
+			{RevisionID: 105},
+		},
+	}
+	verifications, err := client.VerifyProvenance(context.Background(), stored)
+	var fixityErr *FixityError
+	if !errors.As(err, &fixityErr) || !errors.Is(err, ErrFixity) || ErrorClass(err) != ClassFixity {
+		t.Fatalf("Expected a fixity error, received: %v", err)
+	}
+	if !reflect.DeepEqual(fixityErr.Revisions, []int{102, 103, 104, 105}) {
+		t.Errorf("Unexpected revisions failed: %v", fixityErr.Revisions)
+	}
+	expected := []Verification{
+		{Title: "Q12345", Revision: 101, Recorded: sha1, Reported: sha1, Computed: sha1, Verified: true},
+		{Title: "Q12345", Revision: 102, Recorded: "1111111111111111111111111111111111111111", Reported: sha1, Computed: sha1, Reason: ReasonChanged},
+		{Title: "Q12345", Revision: 103, Reported: "0000000000000000000000000000000000000000", Computed: sha1, Reason: ReasonMismatch},
+		{Title: "Q12345", Revision: 104, Reason: ReasonHidden},
+		{Title: "Q12345", Revision: 105, Reason: ReasonMissing},
+	}
+	if !reflect.DeepEqual(verifications, expected) {
+		t.Errorf("Unexpected verifications, received:\n%+v\nexpected:\n%+v", verifications, expected)
+	}
+
+	testServer.Config.Handler = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("revids") != "101" {
+			t.Errorf("Unexpected request for content: '%s'", req.URL.RawQuery)
+		}
+		fmt.Fprintln(res, testContentJSON)
+	})
+	verification, err := client.VerifyRevision(context.Background(), "Q12345", 101)
+	if err != nil || !verification.Verified || verification.Computed != sha1 {
+		t.Errorf("Expected revision 101 to be verified, received: %+v (%v)", verification, err)
+	}
+}
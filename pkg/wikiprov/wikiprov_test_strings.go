@@ -68,3 +68,596 @@ const testJSON string = `{
         }
     }
 }`
+
+// testPageOne, testPageTwo, and testPageThree provide a revision
+// history split across three responses so that we can test following
+// the API's continuation.
+const testPageOne string = `{
+    "continue": {
+        "continue": "||",
+        "rvcontinue": "20210511165245|1419073622"
+    },
+    "query": {
+        "pages": {
+            "13925": {
+                "ns": 0,
+                "pageid": 13925,
+                "revisions": [
+                    {"comment": "edit #1", "parentid": 1419073806, "revid": 1419131078, "timestamp": "2021-05-11T20:17:31Z", "user": "user1"},
+                    {"comment": "edit #2", "parentid": 1419073622, "revid": 1419073806, "timestamp": "2021-05-11T16:53:18Z", "user": "user2"}
+                ],
+                "title": "Q12345"
+            }
+        }
+    }
+}`
+
+const testPageTwo string = `{
+    "continue": {
+        "continue": "||",
+        "rvcontinue": "20210331102719|1393551702"
+    },
+    "query": {
+        "pages": {
+            "13925": {
+                "ns": 0,
+                "pageid": 13925,
+                "revisions": [
+                    {"comment": "edit #3", "parentid": 1419064895, "revid": 1419073622, "timestamp": "2021-05-11T16:52:45Z", "user": "user3"},
+                    {"comment": "edit #4", "parentid": 1393551702, "revid": 1419064895, "timestamp": "2021-05-11T16:16:09Z", "user": "user4"}
+                ],
+                "title": "Q12345"
+            }
+        }
+    }
+}`
+
+const testPageThree string = `{
+    "batchcomplete": "",
+    "query": {
+        "pages": {
+            "13925": {
+                "ns": 0,
+                "pageid": 13925,
+                "revisions": [
+                    {"comment": "edit #5", "parentid": 0, "revid": 1393551702, "timestamp": "2021-03-31T10:27:19Z", "user": "user5"}
+                ],
+                "title": "Q12345"
+            }
+        }
+    }
+}`
+
+// testRevisionJSON provides revisions with the optional properties
+// that can be requested from the API, e.g. size, tags, and flags.
+const testRevisionJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "pages": {
+            "13925": {
+                "ns": 0,
+                "pageid": 13925,
+                "revisions": [
+                    {"comment": "edit #1", "parentid": 1419073806, "revid": 1419131078, "timestamp": "2021-05-11T20:17:31Z", "user": "user1", "sha1": "4fa4f3344e2db600c11273028e63ba21976ede80", "size": 1024, "tags": ["mw-undo"], "minor": ""},
+                    {"comment": "edit #2", "parentid": 0, "revid": 1419073806, "timestamp": "2021-05-11T16:53:18Z", "user": "user2", "sha1": "88a134dc3b112584e143003cadf0fdf3a4503dfe", "size": 512, "tags": []}
+                ],
+                "title": "Q12345"
+            }
+        }
+    }
+}`
+
+// summaryTest and summaryTests provide table-driven testing of the
+// edit summary parser. Summaries are taken from Wikidata.
+type summaryTest struct {
+	comment  string
+	expected EditSummary
+}
+
+var summaryTests = []summaryTest{
+	{
+		"/* wbsetclaim-update:2||1 */ [[Property:P4152]]: B297E169",
+		EditSummary{
+			Action:     "wbsetclaim-update",
+			Args:       []string{"", "1"},
+			Properties: []string{"P4152"},
+			Value:      "B297E169",
+			Text:       "[[Property:P4152]]: B297E169",
+		},
+	},
+	{
+		"/* wbsetlabel-add:1|ru */ Envoy",
+		EditSummary{
+			Action:    "wbsetlabel-add",
+			Args:      []string{"ru"},
+			Languages: []string{"ru"},
+			Value:     "Envoy",
+			Text:      "Envoy",
+		},
+	},
+	{
+		"/* wbeditentity-update-languages-short:0||ga, nl */ Irish label added",
+		EditSummary{
+			Action:    "wbeditentity-update-languages-short",
+			Args:      []string{"", "ga, nl"},
+			Languages: []string{"ga", "nl"},
+			Value:     "Irish label added",
+			Text:      "Irish label added",
+		},
+	},
+	{
+		"/* undo:0||2235229659|189.214.7.137 */",
+		EditSummary{
+			Action: "undo",
+			Args:   []string{"", "2235229659", "189.214.7.137"},
+		},
+	},
+	{
+		"/* wbmergeitems-from:0||Q123456 */",
+		EditSummary{
+			Action: "wbmergeitems-from",
+			Args:   []string{"", "Q123456"},
+		},
+	},
+	{
+		"/* wbcreateclaim-create:1| */ [[Property:P8345]]: [[Q106804572]], #quickstatements; #temporary_batch_1620750589351",
+		EditSummary{
+			Action:     "wbcreateclaim-create",
+			Args:       []string{""},
+			Properties: []string{"P8345"},
+			Value:      "[[Q106804572]]",
+			Text:       "[[Property:P8345]]: [[Q106804572]], #quickstatements; #temporary_batch_1620750589351",
+			Tools:      []ToolLink{{Tool: "quickstatements", Batch: "temporary_batch_1620750589351"}},
+		},
+	},
+	{
+		"/* wbsetdescription-add:1|uk */ формат файлу, [[:toollabs:quickstatements/#/batch/151018|batch #151018]]",
+		EditSummary{
+			Action:    "wbsetdescription-add",
+			Args:      []string{"uk"},
+			Languages: []string{"uk"},
+			Value:     "формат файлу",
+			Text:      "формат файлу, [[:toollabs:quickstatements/#/batch/151018|batch #151018]]",
+			Tools:     []ToolLink{{Tool: "quickstatements", Batch: "151018", Link: "#/batch/151018"}},
+		},
+	},
+	{
+		"/* wbsetclaim-create:2||1 */ [[Property:P2748]]: fmt/1286 ([[:toollabs:editgroups/b/OR/6a4f9a3a7|details]])",
+		EditSummary{
+			Action:     "wbsetclaim-create",
+			Args:       []string{"", "1"},
+			Properties: []string{"P2748"},
+			Value:      "fmt/1286",
+			Text:       "[[Property:P2748]]: fmt/1286 ([[:toollabs:editgroups/b/OR/6a4f9a3a7|details]])",
+			Tools:      []ToolLink{{Tool: "editgroups", Batch: "OR/6a4f9a3a7", Link: "b/OR/6a4f9a3a7"}},
+		},
+	},
+	{
+		"a summary written by hand",
+		EditSummary{
+			Value: "a summary written by hand",
+			Text:  "a summary written by hand",
+		},
+	},
+}
+
+// testFilterPageOne and testFilterPageTwo provide a revision history
+// where edits to properties are interspersed with edits to labels so
+// that we can test filtering history by property.
+const testFilterPageOne string = `{
+    "continue": {
+        "continue": "||",
+        "rvcontinue": "20200804234010|1247208427"
+    },
+    "query": {
+        "pages": {
+            "5147078": {
+                "ns": 0,
+                "pageid": 5147078,
+                "revisions": [
+                    {"comment": "/* wbsetlabel-add:1|ru */ Envoy", "parentid": 1732599165, "revid": 1874135847, "timestamp": "2023-04-13T12:30:22Z", "user": "user1"},
+                    {"comment": "/* wbsetlabel-add:1|ga */ Envoy", "parentid": 1247209137, "revid": 1732599165, "timestamp": "2022-09-20T07:09:09Z", "user": "user2"},
+                    {"comment": "/* wbsetclaim-update:2||1 */ [[Property:P4152]]: B297E169", "parentid": 1247208427, "revid": 1247209137, "timestamp": "2020-08-04T23:41:27Z", "user": "user3"}
+                ],
+                "title": "Q5381415"
+            }
+        }
+    }
+}`
+
+const testFilterPageTwo string = `{
+    "batchcomplete": "",
+    "query": {
+        "pages": {
+            "5147078": {
+                "ns": 0,
+                "pageid": 5147078,
+                "revisions": [
+                    {"comment": "/* wbsetclaim-update:2||1 */ [[Property:P4152]]: 325E1010", "parentid": 1120067133, "revid": 1247208427, "timestamp": "2020-08-04T23:40:10Z", "user": "user4"},
+                    {"comment": "/* wbsetaliases-add:3|en */ Envoy Document File", "parentid": 1120066880, "revid": 1120067133, "timestamp": "2020-02-21T14:40:33Z", "user": "user5"},
+                    {"comment": "/* wbsetclaim-create:2||1 */ [[Property:P2748]]: fmt/1286", "parentid": 0, "revid": 1120066880, "timestamp": "2020-02-21T14:38:44Z", "user": "user6"}
+                ],
+                "title": "Q5381415"
+            }
+        }
+    }
+}`
+
+// testMaxLagJSON is the error returned by the MediaWiki API when the
+// maxlag parameter is exceeded.
+const testMaxLagJSON string = `{
+    "error": {
+        "code": "maxlag",
+        "info": "Waiting for 10.64.48.35: 0.64 seconds lagged.",
+        "host": "10.64.48.35",
+        "lag": 0.64,
+        "type": "db",
+        "*": "See https://www.wikidata.org/w/api.php for API usage."
+    },
+    "servedby": "mw1234"
+}`
+
+// testMissingJSON is returned by the MediaWiki API when the entity
+// requested doesn't exist.
+const testMissingJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "pages": {
+            "-1": {
+                "ns": 0,
+                "title": "Q999999999999",
+                "missing": ""
+            }
+        }
+    }
+}`
+
+// testAPIErrorJSON is an error returned by the MediaWiki API for a bad
+// request.
+const testAPIErrorJSON string = `{
+    "error": {
+        "code": "badvalue",
+        "info": "Unrecognized value for parameter \"prop\": revision.",
+        "*": "See https://www.wikidata.org/w/api.php for API usage."
+    },
+    "servedby": "mw1234"
+}`
+
+// testRedirectJSON is returned by the MediaWiki API for an item that
+// has been merged into another and is now a redirect to it.
+const testRedirectJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "pages": {
+            "3212121": {
+                "pageid": 3212121,
+                "ns": 0,
+                "title": "Q1111",
+                "redirect": "",
+                "lastrevid": 300,
+                "revisions": [
+                    {
+                        "revid": 300,
+                        "parentid": 200,
+                        "user": "Emmanuel Goldstein",
+                        "timestamp": "2021-05-11T16:30:11Z",
+                        "sha1": "2ae4b1e4ca16d2b6a8e3bbbd0d4e2a2f3fe3b2d1",
+                        "comment": "/* wbmergeitems-to:0||Q2222 */"
+                    }
+                ]
+            }
+        }
+    }
+}`
+
+// testResolvedRedirectJSON is returned by the MediaWiki API when it is
+// asked to resolve the redirect in testRedirectJSON.
+const testResolvedRedirectJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "redirects": [
+            {
+                "from": "Q1111",
+                "to": "Q2222"
+            }
+        ],
+        "pages": {
+            "2222": {
+                "pageid": 2222,
+                "ns": 0,
+                "title": "Q2222"
+            }
+        }
+    }
+}`
+
+// testRedirectTargetJSON is the history of the item testRedirectJSON
+// redirects to.
+const testRedirectTargetJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "pages": {
+            "2222": {
+                "pageid": 2222,
+                "ns": 0,
+                "title": "Q2222",
+                "lastrevid": 301,
+                "revisions": [
+                    {
+                        "revid": 301,
+                        "parentid": 100,
+                        "user": "Emmanuel Goldstein",
+                        "timestamp": "2021-05-11T16:30:12Z",
+                        "sha1": "c9c3c6d6e1a1e6ba4cb2c5a4f5e9d0a7f1e1b2c3",
+                        "comment": "/* wbmergeitems-from:0||Q1111 */"
+                    }
+                ]
+            }
+        }
+    }
+}`
+
+// testDeletedLogJSON is the deletion log of an entity that has been
+// deleted.
+const testDeletedLogJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "logevents": [
+            {
+                "logid": 12345,
+                "ns": 0,
+                "title": "Q999999999999",
+                "type": "delete",
+                "action": "delete",
+                "timestamp": "2022-01-01T00:00:00Z",
+                "comment": "Does not meet the notability policy"
+            }
+        ]
+    }
+}`
+
+// testEmptyLogJSON is the deletion log of an entity that has never
+// been deleted.
+const testEmptyLogJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "logevents": []
+    }
+}`
+
+// testRevisionPropertiesJSON includes every revision property that can
+// be requested.
+const testRevisionPropertiesJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "pages": {
+            "5147078": {
+                "pageid": 5147078,
+                "ns": 0,
+                "revisions": [
+                    {"comment": "/* undo:0||1419073806|user2 */", "parentid": 1419073806, "revid": 1419131078, "timestamp": "2021-05-11T20:17:31Z", "user": "user1", "userid": 4321, "sha1": "4fa4f3344e2db600c11273028e63ba21976ede80", "size": 1024, "tags": ["mw-undo", "OAuth CID: 1776"], "minor": "", "contentmodel": "wikibase-item"}
+                ],
+                "title": "Q12345"
+            }
+        }
+    }
+}`
+
+// testBatchJSON is returned by the MediaWiki API when the latest
+// revisions of Q12345, Q1111, Q999999999999, and Q2222 are requested
+// at once. Q1111 is a redirect and Q999999999999 is missing so they
+// need to be requested individually.
+const testBatchJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "normalized": [
+            {"from": "item:Q12345", "to": "Q12345"},
+            {"from": "item:Q1111", "to": "Q1111"},
+            {"from": "item:Q999999999999", "to": "Q999999999999"},
+            {"from": "item:Q2222", "to": "Q2222"}
+        ],
+        "pages": {
+            "-1": {
+                "ns": 0,
+                "title": "Q999999999999",
+                "missing": ""
+            },
+            "5147078": {
+                "pageid": 5147078,
+                "ns": 0,
+                "title": "Q12345",
+                "lastrevid": 1419131078,
+                "revisions": [
+                    {"comment": "edit #1", "parentid": 1419073806, "revid": 1419131078, "timestamp": "2021-05-11T20:17:31Z", "user": "user1"}
+                ]
+            },
+            "3212121": {
+                "pageid": 3212121,
+                "ns": 0,
+                "title": "Q1111",
+                "redirect": "",
+                "lastrevid": 300,
+                "revisions": [
+                    {"comment": "/* wbmergeitems-to:0||Q2222 */", "parentid": 200, "revid": 300, "timestamp": "2021-05-11T16:30:11Z", "user": "Emmanuel Goldstein"}
+                ]
+            },
+            "2222": {
+                "pageid": 2222,
+                "ns": 0,
+                "title": "Q2222",
+                "lastrevid": 301,
+                "revisions": [
+                    {"comment": "/* wbmergeitems-from:0||Q1111 */", "parentid": 100, "revid": 301, "timestamp": "2021-05-11T16:30:12Z", "user": "Emmanuel Goldstein"}
+                ]
+            }
+        }
+    }
+}`
+
+// testCacheInfoJSON is returned by the MediaWiki API when information
+// about Q12345 and Q2222 is requested. The latest revision of Q2222 is
+// formatted into it.
+const testCacheInfoJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "normalized": [
+            {"from": "item:Q12345", "to": "Q12345"},
+            {"from": "item:Q2222", "to": "Q2222"}
+        ],
+        "pages": {
+            "5147078": {"pageid": 5147078, "ns": 0, "title": "Q12345", "lastrevid": 1419131078},
+            "2222": {"pageid": 2222, "ns": 0, "title": "Q2222", "lastrevid": %d}
+        }
+    }
+}`
+
+// testRefreshJSON is returned by the MediaWiki API when the revisions
+// of Q12345 are requested back to revision 1419073806, which is
+// included in the results.
+const testRefreshJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "normalized": [{"from": "item:Q12345", "to": "Q12345"}],
+        "pages": {
+            "5147078": {
+                "pageid": 5147078,
+                "ns": 0,
+                "title": "Q12345",
+                "lastrevid": 1419131080,
+                "revisions": [
+                    {"comment": "/* wbsetclaim-create:2||1 */ [[Property:P2748]]: fmt/1", "parentid": 1419131078, "revid": 1419131080, "timestamp": "2021-05-12T09:00:00Z", "user": "user3"},
+                    {"comment": "edit #2", "parentid": 1419073806, "revid": 1419131078, "timestamp": "2021-05-11T20:17:31Z", "user": "user2"},
+                    {"comment": "edit #1", "parentid": 0, "revid": 1419073806, "timestamp": "2021-05-11T16:30:11Z", "user": "user1"}
+                ]
+            }
+        }
+    }
+}`
+
+// testAsOfJSON is returned by the MediaWiki API when the revisions of
+// Q12345 are requested from 2021-05-11T18:00:00Z backwards.
+const testAsOfJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "normalized": [{"from": "item:Q12345", "to": "Q12345"}],
+        "pages": {
+            "5147078": {
+                "pageid": 5147078,
+                "ns": 0,
+                "title": "Q12345",
+                "lastrevid": 1419131080,
+                "revisions": [
+                    {"comment": "edit #1", "parentid": 0, "revid": 1419073806, "timestamp": "2021-05-11T16:30:11Z", "user": "user1"}
+                ]
+            }
+        }
+    }
+}`
+
+// testBeforeCreationJSON is returned by the MediaWiki API when the
+// revisions of Q12345 are requested from before it was created.
+const testBeforeCreationJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "normalized": [{"from": "item:Q12345", "to": "Q12345"}],
+        "pages": {
+            "5147078": {"pageid": 5147078, "ns": 0, "title": "Q12345", "lastrevid": 1419131080}
+        }
+    }
+}`
+
+// testFilterJSON is the first page of the history of Q12345 made by a
+// mix of users, a bot, and an anonymous editor. A second page could be
+// requested by following its continuation.
+const testFilterJSON string = `{
+    "continue": {"rvcontinue": "20210101000000|100", "continue": "||"},
+    "query": {
+        "normalized": [{"from": "item:Q12345", "to": "Q12345"}],
+        "pages": {
+            "5147078": {
+                "pageid": 5147078,
+                "ns": 0,
+                "title": "Q12345",
+                "revisions": [
+                    {"comment": "edit #5", "parentid": 104, "revid": 105, "timestamp": "2021-06-01T00:00:00Z", "user": "Emmanuel Goldstein"},
+                    {"comment": "edit #4", "parentid": 103, "revid": 104, "timestamp": "2021-05-01T00:00:00Z", "user": "192.0.2.1", "anon": ""},
+                    {"comment": "edit #3", "parentid": 102, "revid": 103, "timestamp": "2021-04-01T00:00:00Z", "user": "Winston Smith"},
+                    {"comment": "edit #2", "parentid": 101, "revid": 102, "timestamp": "2021-03-01T00:00:00Z", "user": "Big Brother Bot"},
+                    {"comment": "edit #1", "parentid": 100, "revid": 101, "timestamp": "2021-02-01T00:00:00Z", "user": "Winston Smith"}
+                ]
+            }
+        }
+    }
+}`
+
+// testUntilJSON is the history of Q12345 in testFilterJSON listed back
+// from the start of April 2021. The latest revision was made later.
+const testUntilJSON string = `{
+    "continue": {"rvcontinue": "20210101000000|100", "continue": "||"},
+    "query": {
+        "normalized": [{"from": "item:Q12345", "to": "Q12345"}],
+        "pages": {
+            "5147078": {
+                "pageid": 5147078,
+                "ns": 0,
+                "title": "Q12345",
+                "lastrevid": 105,
+                "revisions": [
+                    {"comment": "edit #3", "parentid": 102, "revid": 103, "timestamp": "2021-04-01T00:00:00Z", "user": "Winston Smith"},
+                    {"comment": "edit #2", "parentid": 101, "revid": 102, "timestamp": "2021-03-01T00:00:00Z", "user": "Big Brother Bot"},
+                    {"comment": "edit #1", "parentid": 100, "revid": 101, "timestamp": "2021-02-01T00:00:00Z", "user": "Winston Smith"}
+                ]
+            }
+        }
+    }
+}`
+
+// testUserGroupsJSON is returned by the MediaWiki API when the groups
+// of the users who edited Q12345 are requested.
+const testUserGroupsJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "users": [
+            {"userid": 1, "name": "Emmanuel Goldstein", "groups": ["*", "user", "autoconfirmed"]},
+            {"userid": 2, "name": "Winston Smith", "groups": ["*", "user"]},
+            {"userid": 3, "name": "Big Brother Bot", "groups": ["*", "user", "bot"]}
+        ]
+    }
+}`
+
+// testSnapshotJSON is returned by Special:EntityData for Q12345 at
+// revision 1419131078, abridged.
+const testSnapshotJSON string = `{
+    "entities": {
+        "Q12345": {
+            "type": "item",
+            "id": "Q12345",
+            "lastrevid": 1419131078,
+            "labels": {"en": {"language": "en", "value": "Count von Count"}}
+        }
+    }
+}`
+
+// testContentJSON is returned by the MediaWiki API when the content of
+// revisions 101 to 105 of Q12345 is requested. The content of 101, 102,
+// and 103 is identical but 103 reports a different SHA1. The content
+// of 104 is hidden and 105 wasn't found.
+const testContentJSON string = `{
+    "batchcomplete": "",
+    "query": {
+        "badrevids": {"105": {"revid": 105, "missing": ""}},
+        "pages": {
+            "5147078": {
+                "pageid": 5147078,
+                "ns": 0,
+                "title": "Q12345",
+                "revisions": [
+                    {"revid": 101, "parentid": 0, "sha1": "eee69f18962e4cab7be819171050a8f9940dca21", "slots": {"main": {"contentmodel": "wikibase-item", "contentformat": "application/json", "*": "{\"type\":\"item\",\"id\":\"Q12345\"}"}}},
+                    {"revid": 102, "parentid": 101, "sha1": "eee69f18962e4cab7be819171050a8f9940dca21", "slots": {"main": {"contentmodel": "wikibase-item", "contentformat": "application/json", "*": "{\"type\":\"item\",\"id\":\"Q12345\"}"}}},
+                    {"revid": 103, "parentid": 102, "sha1": "0000000000000000000000000000000000000000", "slots": {"main": {"contentmodel": "wikibase-item", "contentformat": "application/json", "*": "{\"type\":\"item\",\"id\":\"Q12345\"}"}}},
+                    {"revid": 104, "parentid": 103, "sha1hidden": "", "texthidden": "", "slots": {"main": {"texthidden": ""}}}
+                ]
+            }
+        }
+    }
+}`